@@ -4,7 +4,9 @@ import (
 	"context"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/grafana/alloy/internal/component"
@@ -13,6 +15,7 @@ import (
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/serialization"
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
 	"github.com/grafana/alloy/internal/featuregate"
+	"github.com/grafana/alloy/internal/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/storage"
 )
@@ -35,6 +38,7 @@ func NewComponent(opts component.Options, args Arguments) (*Queue, error) {
 		args:      args,
 		log:       opts.Logger,
 		endpoints: map[string]*endpoint{},
+		stats:     map[string]*endpointStats{},
 	}
 
 	err := s.createEndpoints()
@@ -59,6 +63,17 @@ type Queue struct {
 	opts      component.Options
 	log       log.Logger
 	endpoints map[string]*endpoint
+	// stats are kept separate from the endpoints lifecycle so that counters survive
+	// an endpoint being recreated on config change.
+	stats map[string]*endpointStats
+}
+
+// endpointStats holds the metrics for a single endpoint, reg is used to unregister them
+// when the endpoint is removed from the configuration.
+type endpointStats struct {
+	series *types.PrometheusStats
+	meta   *types.PrometheusStats
+	reg    util.Unregisterer
 }
 
 // Run starts the component, blocking until ctx is canceled or the component
@@ -69,9 +84,16 @@ func (s *Queue) Run(ctx context.Context) error {
 		s.mut.Lock()
 		defer s.mut.Unlock()
 
+		// The endpoints send what they have queued in parallel so that shutting down takes at most the longest drain_timeout.
+		var wg sync.WaitGroup
 		for _, ep := range s.endpoints {
-			ep.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ep.StopWithTimeout(ep.drainTimeout)
+			}()
 		}
+		wg.Wait()
 	}()
 
 	<-ctx.Done()
@@ -118,13 +140,14 @@ func (s *Queue) Update(args component.Arguments) error {
 
 func (s *Queue) createEndpoints() error {
 	// @mattdurham not in love with this code.
+	s.removeStaleStats()
 	for _, ep := range s.args.Endpoints {
-		reg := prometheus.WrapRegistererWith(prometheus.Labels{"endpoint": ep.Name}, s.opts.Registerer)
-		stats := types.NewStats("alloy", "queue_series", reg)
-		stats.SeriesBackwardsCompatibility(reg)
-		meta := types.NewStats("alloy", "queue_metadata", reg)
-		meta.MetaBackwardsCompatibility(reg)
+		st := s.getOrCreateStats(ep.Name)
+		// The connections are recreated, so drop the series of any that no longer exist.
+		st.series.ResetConnectionMetrics()
+		stats, meta := st.series, st.meta
 		cfg := ep.ToNativeType()
+		cfg.TTL = s.args.TTL
 		client, err := network.New(cfg, s.log, stats.UpdateNetwork, meta.UpdateNetwork)
 		if err != nil {
 			return err
@@ -137,18 +160,61 @@ func (s *Queue) createEndpoints() error {
 			return err
 		}
 		serial, err := serialization.NewSerializer(types.SerializerConfig{
-			MaxSignalsInBatch: uint32(s.args.Persistence.MaxSignalsToBatch),
-			FlushFrequency:    s.args.Persistence.BatchInterval,
+			MaxSignalsInBatch:    uint32(s.args.Persistence.MaxSignalsToBatch),
+			FlushFrequency:       s.args.Persistence.BatchInterval,
+			SeriesChurnCacheSize: s.args.SeriesChurnCacheSize,
+			SuppressUnchanged:    s.args.SuppressUnchanged.ToNativeType(),
 		}, fq, stats.UpdateSerializer, s.opts.Logger)
 		if err != nil {
 			return err
 		}
 		end.serializer = serial
+		end.acceptedTypes = types.NewAcceptedTypes(ep.AcceptedTypes)
+		end.sendCreatedTimestamps = ep.SendCreatedTimestamps
+		end.appenderStats = stats.UpdateAppender
+		end.drainTimeout = ep.DrainTimeout
 		s.endpoints[ep.Name] = end
 	}
 	return nil
 }
 
+// getOrCreateStats returns the metrics for the named endpoint, reusing the already registered collectors
+// if the endpoint existed before. This keeps counters from resetting when the component is updated.
+func (s *Queue) getOrCreateStats(name string) *endpointStats {
+	if st, found := s.stats[name]; found {
+		return st
+	}
+	reg := util.WrapWithUnregisterer(prometheus.WrapRegistererWith(prometheus.Labels{"endpoint": name}, s.opts.Registerer))
+	stats := types.NewStats("alloy", "queue_series", reg)
+	stats.SeriesBackwardsCompatibility(reg)
+	meta := types.NewStats("alloy", "queue_metadata", reg)
+	meta.MetaBackwardsCompatibility(reg)
+	st := &endpointStats{
+		series: stats,
+		meta:   meta,
+		reg:    reg,
+	}
+	s.stats[name] = st
+	return st
+}
+
+// removeStaleStats unregisters the metrics of any endpoint that is no longer configured.
+func (s *Queue) removeStaleStats() {
+	for name, st := range s.stats {
+		found := false
+		for _, ep := range s.args.Endpoints {
+			if ep.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			st.reg.UnregisterAll()
+			delete(s.stats, name)
+		}
+	}
+}
+
 // Appender returns a new appender for the storage. The implementation
 // can choose whether or not to use the context, for deadlines or to check
 // for errors.
@@ -158,7 +224,54 @@ func (c *Queue) Appender(ctx context.Context) storage.Appender {
 
 	children := make([]storage.Appender, 0)
 	for _, ep := range c.endpoints {
-		children = append(children, serialization.NewAppender(ctx, c.args.TTL, ep.serializer, c.opts.Logger))
+		children = append(children, serialization.NewAppender(ctx, c.args.TTL, ep.serializer, ep.acceptedTypes, ep.sendCreatedTimestamps, ep.appenderStats, c.opts.Logger))
 	}
 	return &fanout{children: children}
 }
+
+// DebugInfo returns the state of the network queues of each endpoint.
+func (s *Queue) DebugInfo() interface{} {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	var res queueDebugInfo
+	for name, ep := range s.endpoints {
+		status := ep.network.Status()
+		info := endpointDebugInfo{
+			Name:                name,
+			Connections:         status.Connections,
+			MetadataConnections: status.MetadataConnections,
+			Unrouted:            status.Unrouted,
+			PendingSeries:       status.PendingSeries,
+			PendingMetadata:     status.PendingMetadata,
+			Protocol:            status.Protocol,
+			Compression:         status.Compression,
+		}
+		if status.LastError != nil {
+			info.LastError = status.LastError.Error()
+			info.LastErrorTime = status.LastErrorTime
+		}
+		res.Endpoints = append(res.Endpoints, info)
+	}
+	sort.Slice(res.Endpoints, func(i, j int) bool {
+		return res.Endpoints[i].Name < res.Endpoints[j].Name
+	})
+	return res
+}
+
+type queueDebugInfo struct {
+	Endpoints []endpointDebugInfo `alloy:"endpoint,block"`
+}
+
+type endpointDebugInfo struct {
+	Name                string    `alloy:"name,attr"`
+	Connections         int       `alloy:"connections,attr"`
+	MetadataConnections int       `alloy:"metadata_connections,attr"`
+	Unrouted            int64     `alloy:"unrouted,attr"`
+	PendingSeries       int64     `alloy:"pending_series,attr"`
+	PendingMetadata     int64     `alloy:"pending_metadata,attr"`
+	Protocol            string    `alloy:"protocol,attr"`
+	Compression         string    `alloy:"compression,attr"`
+	LastError           string    `alloy:"last_error,attr,optional"`
+	LastErrorTime       time.Time `alloy:"last_error_time,attr,optional"`
+}
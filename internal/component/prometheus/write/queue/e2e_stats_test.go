@@ -48,6 +48,20 @@ const alloyMetadataRetried429 = "alloy_queue_metadata_network_retried_429"
 const alloyMetadataRetried = "alloy_queue_metadata_network_retried"
 
 const alloyNetworkTimestamp = "alloy_queue_series_network_timestamp_seconds"
+const alloyUncompressedBytes = "alloy_queue_series_network_uncompressed_bytes"
+const alloyMetadataUncompressedBytes = "alloy_queue_metadata_network_uncompressed_bytes"
+const alloyQueueUtilization = "alloy_queue_series_network_queue_utilization"
+const alloyPendingSamples = "alloy_queue_series_network_pending_samples"
+const alloyPendingHistograms = "alloy_queue_series_network_pending_histograms"
+const alloyLowestPendingTimestamp = "alloy_queue_series_network_lowest_pending_timestamp_seconds"
+const alloyBatches = "alloy_queue_series_network_batches"
+const alloyMetadataBatches = "alloy_queue_metadata_network_batches"
+const alloyLag = "alloy_queue_series_network_lag_seconds"
+const alloyRequestsSent = "alloy_queue_series_network_requests_sent"
+const alloyMetadataRequestsSent = "alloy_queue_metadata_network_requests_sent"
+const alloySampleAge = "alloy_queue_series_network_sample_age_seconds"
+const alloyConnectDuration = "alloy_queue_series_network_connect_duration_seconds"
+const alloyMetadataConnectDuration = "alloy_queue_metadata_network_connect_duration_seconds"
 
 // TestMetadata is the large end to end testing for the queue based wal, specifically for metadata.
 func TestMetadata(t *testing.T) {
@@ -60,6 +74,26 @@ func TestMetadata(t *testing.T) {
 			returnStatusCode: http.StatusOK,
 			dtype:            Metadata,
 			checks: []check{
+				{
+					name:      alloyMetadataConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyMetadataRequestsSent,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyMetadataBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -80,6 +114,10 @@ func TestMetadata(t *testing.T) {
 					name:  alloyMetadataSent,
 					value: 10,
 				},
+				{
+					name:      alloyMetadataUncompressedBytes,
+					valueFunc: greaterThenZero,
+				},
 			},
 		},
 		{
@@ -87,6 +125,22 @@ func TestMetadata(t *testing.T) {
 			returnStatusCode: http.StatusBadRequest,
 			dtype:            Metadata,
 			checks: []check{
+				{
+					name:      alloyMetadataConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyMetadataBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  alloyMetadataFailed,
 					value: 10,
@@ -110,6 +164,22 @@ func TestMetadata(t *testing.T) {
 			returnStatusCode: http.StatusTooManyRequests,
 			dtype:            Metadata,
 			checks: []check{
+				{
+					name:      alloyMetadataConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyMetadataBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -153,6 +223,26 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusOK,
 			dtype:            Sample,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyRequestsSent,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -193,6 +283,10 @@ func TestMetrics(t *testing.T) {
 					name:      alloyNetworkTimestamp,
 					valueFunc: greaterThenZero,
 				},
+				{
+					name:      alloyUncompressedBytes,
+					valueFunc: greaterThenZero,
+				},
 			},
 		},
 		{
@@ -200,6 +294,22 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusBadRequest,
 			dtype:            Sample,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  alloyFailures,
 					value: 10,
@@ -235,6 +345,26 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusTooManyRequests,
 			dtype:            Sample,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyLowestPendingTimestamp,
+					valueFunc: isZeroOrRecentTimeStamp,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -254,6 +384,15 @@ func TestMetrics(t *testing.T) {
 					// This will be more than 10 since it retries in a loop.
 					valueFunc: greaterThenZero,
 				},
+				{
+					name: alloyQueueUtilization,
+					// Series back up in the queue while the batch is retried.
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyPendingSamples,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:      prometheusDuration,
 					valueFunc: greaterThenZero,
@@ -278,6 +417,26 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusOK,
 			dtype:            Histogram,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyRequestsSent,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -318,6 +477,10 @@ func TestMetrics(t *testing.T) {
 					name:      alloyNetworkTimestamp,
 					valueFunc: greaterThenZero,
 				},
+				{
+					name:      alloyUncompressedBytes,
+					valueFunc: greaterThenZero,
+				},
 			},
 		},
 		{
@@ -325,6 +488,22 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusBadRequest,
 			dtype:            Histogram,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  alloyFailures,
 					value: 10,
@@ -360,6 +539,26 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusTooManyRequests,
 			dtype:            Histogram,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyLowestPendingTimestamp,
+					valueFunc: isZeroOrRecentTimeStamp,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -379,6 +578,15 @@ func TestMetrics(t *testing.T) {
 					// This will be more than 10 since it retries in a loop.
 					valueFunc: greaterThenZero,
 				},
+				{
+					name: alloyQueueUtilization,
+					// Series back up in the queue while the batch is retried.
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyPendingHistograms,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:      prometheusDuration,
 					valueFunc: greaterThenZero,
@@ -403,6 +611,26 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusOK,
 			dtype:            Exemplar,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyRequestsSent,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -443,6 +671,10 @@ func TestMetrics(t *testing.T) {
 					name:      alloyNetworkTimestamp,
 					valueFunc: greaterThenZero,
 				},
+				{
+					name:      alloyUncompressedBytes,
+					valueFunc: greaterThenZero,
+				},
 			},
 		},
 		{
@@ -450,6 +682,22 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusBadRequest,
 			dtype:            Exemplar,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:  alloyFailures,
 					value: 10,
@@ -485,6 +733,26 @@ func TestMetrics(t *testing.T) {
 			returnStatusCode: http.StatusTooManyRequests,
 			dtype:            Exemplar,
 			checks: []check{
+				{
+					name:      alloyConnectDuration,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloySampleAge,
+					valueFunc: isNotNegative,
+				},
+				{
+					name:      alloyLag,
+					valueFunc: isSmallLag,
+				},
+				{
+					name:      alloyBatches,
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyLowestPendingTimestamp,
+					valueFunc: isZeroOrRecentTimeStamp,
+				},
 				{
 					name:  serializerIncoming,
 					value: 10,
@@ -504,6 +772,15 @@ func TestMetrics(t *testing.T) {
 					// This will be more than 10 since it retries in a loop.
 					valueFunc: greaterThenZero,
 				},
+				{
+					name: alloyQueueUtilization,
+					// Series back up in the queue while the batch is retried.
+					valueFunc: greaterThenZero,
+				},
+				{
+					name:      alloyPendingSamples,
+					valueFunc: greaterThenZero,
+				},
 				{
 					name:      prometheusDuration,
 					valueFunc: greaterThenZero,
@@ -544,6 +821,27 @@ func isReasonableTimeStamp(v float64) bool {
 	return time.Since(unixTime) < 10*time.Second
 }
 
+// isSmallLag is true for the lag between timestamps that are only a few seconds apart.
+func isSmallLag(v float64) bool {
+	return v >= 0 && v < 10
+}
+
+func isNotNegative(v float64) bool {
+	return v >= 0
+}
+
+// isZeroOrRecentTimeStamp is looser than isReasonableTimeStamp, a batch being retried keeps the timestamp of its
+// oldest series for as long as the test waits, and it is 0 once the retries are done.
+func isZeroOrRecentTimeStamp(v float64) bool {
+	if v == 0 {
+		return true
+	}
+	if v < 0 {
+		return false
+	}
+	return time.Since(time.Unix(int64(v), 0)) < 1*time.Minute
+}
+
 type dataType int
 
 const (
@@ -689,3 +987,58 @@ func checkValueCondition(t *testing.T, name string, chk func(float64) bool, metr
 	delete(metrics, name)
 	return metrics
 }
+
+// TestMetricsSurviveUpdate ensures that updating the component keeps the already registered metrics.
+func TestMetricsSurviveUpdate(t *testing.T) {
+	l := util.TestAlloyLogger(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	expCh := make(chan Exports, 1)
+
+	reg := prometheus.NewRegistry()
+	c, err := newComponent(t, l, srv.URL, expCh, reg)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		runErr := c.Run(ctx)
+		require.NoError(t, runErr)
+	}()
+	exp := <-expCh
+
+	app := exp.Receiver.Appender(ctx)
+	for i := 0; i < 10; i++ {
+		ts, v, lbls := makeSeries(i)
+		_, errApp := app.Append(0, lbls, ts, v)
+		require.NoError(t, errApp)
+	}
+	require.NoError(t, app.Commit())
+
+	require.Eventually(t, func() bool {
+		return gatherValue(t, reg, remoteSamples) == 10
+	}, 10*time.Second, 100*time.Millisecond)
+
+	args := c.args
+	args.Endpoints = []EndpointConfig{args.Endpoints[0]}
+	args.Endpoints[0].BatchCount = 10
+	require.NoError(t, c.Update(args))
+	require.Equal(t, float64(10), gatherValue(t, reg, remoteSamples))
+
+	// Removing the endpoint should remove its metrics.
+	args.Endpoints = nil
+	require.NoError(t, c.Update(args))
+	require.Equal(t, float64(0), gatherValue(t, reg, remoteSamples))
+}
+
+func gatherValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	dtos, err := reg.Gather()
+	require.NoError(t, err)
+	for _, d := range dtos {
+		if *d.Name == name {
+			return getValue(d)
+		}
+	}
+	return 0
+}
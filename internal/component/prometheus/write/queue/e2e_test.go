@@ -51,9 +51,9 @@ func TestE2E(t *testing.T) {
 					require.True(t, len(s.Samples) == 1)
 					require.True(t, s.Samples[0].Timestamp > 0)
 					require.True(t, s.Samples[0].Value > 0)
-					require.True(t, len(s.Labels) == 1)
-					require.Truef(t, s.Labels[0].Name == fmt.Sprintf("name_%d", int(s.Samples[0].Value)), "%d name %s", int(s.Samples[0].Value), s.Labels[0].Name)
-					require.True(t, s.Labels[0].Value == fmt.Sprintf("value_%d", int(s.Samples[0].Value)))
+					require.True(t, len(s.Labels) == 2)
+					require.Truef(t, s.Labels[1].Name == fmt.Sprintf("name_%d", int(s.Samples[0].Value)), "%d name %s", int(s.Samples[0].Value), s.Labels[1].Name)
+					require.True(t, s.Labels[1].Value == fmt.Sprintf("value_%d", int(s.Samples[0].Value)))
 				}
 			},
 		},
@@ -92,7 +92,7 @@ func TestE2E(t *testing.T) {
 					require.True(t, len(s.Samples) == 1)
 					require.True(t, s.Samples[0].Timestamp > 0)
 					require.True(t, s.Samples[0].Value == 0)
-					require.True(t, len(s.Labels) == 1)
+					require.True(t, len(s.Labels) == 2)
 					histSame(t, hist(int(s.Histograms[0].Sum)), s.Histograms[0])
 				}
 			},
@@ -112,7 +112,7 @@ func TestE2E(t *testing.T) {
 					require.True(t, len(s.Samples) == 1)
 					require.True(t, s.Samples[0].Timestamp > 0)
 					require.True(t, s.Samples[0].Value == 0)
-					require.True(t, len(s.Labels) == 1)
+					require.True(t, len(s.Labels) == 2)
 					histFloatSame(t, histFloat(int(s.Histograms[0].Sum)), s.Histograms[0])
 				}
 			},
@@ -216,6 +216,100 @@ func runTest(t *testing.T, add func(index int, appendable storage.Appender) (flo
 	}, 2*time.Second, 100*time.Millisecond, "there are %d time series not collected", types.OutStandingTimeSeriesBinary.Load())
 }
 
+func TestDrainOnStop(t *testing.T) {
+	l := util.TestAlloyLogger(t)
+	series := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		newSamples, _ := handlePost(t, w, r)
+		series.Add(int32(len(newSamples)))
+	}))
+	defer srv.Close()
+	expCh := make(chan Exports, 1)
+	c, err := NewComponent(component.Options{
+		ID:       "test",
+		Logger:   l,
+		DataPath: t.TempDir(),
+		OnStateChange: func(e component.Exports) {
+			expCh <- e.(Exports)
+		},
+		Registerer: prometheus.NewRegistry(),
+	}, Arguments{
+		TTL: 2 * time.Hour,
+		Persistence: Persistence{
+			MaxSignalsToBatch: 10,
+			BatchInterval:     1 * time.Second,
+		},
+		Endpoints: []EndpointConfig{{
+			Name:    "test",
+			URL:     srv.URL,
+			Timeout: 20 * time.Second,
+			// None of these are reached, so the series are only sent while draining.
+			BatchCount:         100,
+			FlushInterval:      1 * time.Minute,
+			StartupGracePeriod: 1 * time.Minute,
+			Parallelism:        2,
+			DrainTimeout:       10 * time.Second,
+		}},
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		require.NoError(t, c.Run(ctx))
+	}()
+	exp := <-expCh
+
+	app := exp.Receiver.Appender(ctx)
+	for i := 0; i < 10; i++ {
+		ts, v, lbls := makeSeries(i)
+		_, err = app.Append(0, lbls, ts, v)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+	// Give the serializer time to write the series and the endpoint to queue them.
+	time.Sleep(3 * time.Second)
+	require.Zero(t, series.Load())
+
+	cancel()
+	<-runDone
+	require.Equal(t, int32(10), series.Load())
+}
+
+func TestDebugInfo(t *testing.T) {
+	l := util.TestAlloyLogger(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	expCh := make(chan Exports, 1)
+	c, err := newComponent(t, l, srv.URL, expCh, prometheus.NewRegistry())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		require.NoError(t, c.Run(ctx))
+	}()
+	exp := <-expCh
+
+	info := c.DebugInfo().(queueDebugInfo)
+	require.Len(t, info.Endpoints, 1)
+	require.Equal(t, "test", info.Endpoints[0].Name)
+	require.Equal(t, 1, info.Endpoints[0].Connections)
+	require.Empty(t, info.Endpoints[0].LastError)
+
+	app := exp.Receiver.Appender(ctx)
+	ts, v, lbls := makeSeries(1)
+	_, err = app.Append(0, lbls, ts, v)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	// The endpoint rejects the batch, which is reported as the last error.
+	require.Eventually(t, func() bool {
+		return c.DebugInfo().(queueDebugInfo).Endpoints[0].LastError != ""
+	}, 10*time.Second, 100*time.Millisecond)
+	require.False(t, c.DebugInfo().(queueDebugInfo).Endpoints[0].LastErrorTime.IsZero())
+}
+
 func handlePost(t *testing.T, _ http.ResponseWriter, r *http.Request) ([]prompb.TimeSeries, []prompb.MetricMetadata) {
 	defer r.Body.Close()
 	data, err := io.ReadAll(r.Body)
@@ -231,7 +325,7 @@ func handlePost(t *testing.T, _ http.ResponseWriter, r *http.Request) ([]prompb.
 }
 
 func makeSeries(index int) (int64, float64, labels.Labels) {
-	return time.Now().UTC().Unix(), float64(index), labels.FromStrings(fmt.Sprintf("name_%d", index), fmt.Sprintf("value_%d", index))
+	return time.Now().UTC().UnixMilli(), float64(index), labels.FromStrings("__name__", "e2e", fmt.Sprintf("name_%d", index), fmt.Sprintf("value_%d", index))
 }
 
 func makeMetadata(index int) (metadata.Metadata, labels.Labels) {
@@ -243,13 +337,13 @@ func makeMetadata(index int) (metadata.Metadata, labels.Labels) {
 }
 
 func makeHistogram(index int) (int64, labels.Labels, *histogram.Histogram) {
-	return time.Now().UTC().Unix(), labels.FromStrings(fmt.Sprintf("name_%d", index), fmt.Sprintf("value_%d", index)), hist(index)
+	return time.Now().UTC().UnixMilli(), labels.FromStrings("__name__", "e2e", fmt.Sprintf("name_%d", index), fmt.Sprintf("value_%d", index)), hist(index)
 }
 
 func makeExemplar(index int) exemplar.Exemplar {
 	return exemplar.Exemplar{
 		Labels: labels.FromStrings(fmt.Sprintf("name_%d", index), fmt.Sprintf("value_%d", index)),
-		Ts:     time.Now().Unix(),
+		Ts:     time.Now().UnixMilli(),
 		HasTs:  true,
 		Value:  float64(index),
 	}
@@ -302,7 +396,7 @@ func histSpanSame(t *testing.T, h []histogram.Span, pb []prompb.BucketSpan) {
 }
 
 func makeFloatHistogram(index int) (int64, labels.Labels, *histogram.FloatHistogram) {
-	return time.Now().UTC().Unix(), labels.FromStrings(fmt.Sprintf("name_%d", index), fmt.Sprintf("value_%d", index)), histFloat(index)
+	return time.Now().UTC().UnixMilli(), labels.FromStrings("__name__", "e2e", fmt.Sprintf("name_%d", index), fmt.Sprintf("value_%d", index)), histFloat(index)
 }
 
 func histFloat(i int) *histogram.FloatHistogram {
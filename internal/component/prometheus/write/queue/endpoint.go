@@ -23,6 +23,13 @@ type endpoint struct {
 	incoming   actor.Mailbox[types.DataHandle]
 	buf        []byte
 	self       actor.Actor
+	// acceptedTypes are the data types appended to the serializer, others are dropped.
+	acceptedTypes types.AcceptedTypes
+	// sendCreatedTimestamps appends a zero sample at the created timestamp of a series.
+	sendCreatedTimestamps bool
+	appenderStats         func(types.AppenderStats)
+	// drainTimeout is how long StopWithTimeout is called with when the component stops.
+	drainTimeout time.Duration
 }
 
 func NewEndpoint(client types.NetworkClient, serializer types.Serializer, ttl time.Duration, logger log.Logger) *endpoint {
@@ -44,10 +51,17 @@ func (ep *endpoint) Start() {
 }
 
 func (ep *endpoint) Stop() {
-	// Stop in order of data flow. This prevents errors around stopped mailboxes that can pop up.
+	ep.StopWithTimeout(0)
+}
+
+// StopWithTimeout stops the endpoint, the network is given up to timeout to send the series it has queued.
+// Series that are still in the file queue are sent once the endpoint starts again.
+func (ep *endpoint) StopWithTimeout(timeout time.Duration) {
+	// Stop in order of data flow. This prevents errors around stopped mailboxes that can pop up, and
+	// nothing new reaches the network while it sends what is queued.
 	ep.serializer.Stop()
-	ep.network.Stop()
 	ep.self.Stop()
+	ep.network.StopWithTimeout(timeout)
 }
 
 func (ep *endpoint) DoWork(ctx actor.Context) actor.WorkerStatus {
@@ -113,7 +127,7 @@ func (ep *endpoint) deserializeAndSend(ctx context.Context, meta map[string]stri
 		// One last chance to check the TTL. Writing to the filequeue will check it but
 		// in a situation where the network is down and writing backs up we dont want to send
 		// data that will get rejected.
-		seriesAge := time.Since(time.Unix(series.TS, 0))
+		seriesAge := time.Since(time.UnixMilli(series.TS))
 		if seriesAge > ep.ttl {
 			// TODO @mattdurham add metric here for ttl expired.
 			continue
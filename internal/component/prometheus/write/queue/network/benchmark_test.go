@@ -2,8 +2,15 @@ package network
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"slices"
 	"testing"
 
+	"github.com/go-kit/log"
+	"github.com/golang/protobuf/proto"
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/vladopajic/go-actor/actor"
 )
 
@@ -22,3 +29,86 @@ func BenchmarkMailbox(b *testing.B) {
 		mbx.Send(ctx, struct{}{})
 	}
 }
+
+func BenchmarkCompression(b *testing.B) {
+	series := make([]*types.TimeSeriesBinary, 0, 1_000)
+	for i := 0; i < 1_000; i++ {
+		series = append(series, createSeries(nil))
+	}
+	data, _, err := createWriteRequest(&prompb.WriteRequest{}, series, nil, proto.NewBuffer(nil))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, compression := range []string{types.CompressionSnappy, types.CompressionZstd, types.CompressionGzip} {
+		b.Run(compression, func(b *testing.B) {
+			l := newLoop(types.ConnectionConfig{
+				BatchCount:  1_000,
+				Compression: compression,
+			}, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+			defer l.ticker.Stop()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.sendBuffer = l.compress(data)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(len(data))/float64(len(l.sendBuffer)), "ratio")
+		})
+	}
+}
+
+func BenchmarkBuildRequestSmall(b *testing.B) {
+	// A sparse queue sends a handful of series at a time.
+	series := make([]*types.TimeSeriesBinary, 0, 5)
+	for i := 0; i < 5; i++ {
+		series = append(series, createSeries(nil))
+	}
+	for _, minBytes := range []int{0, 1_000} {
+		b.Run(fmt.Sprintf("compression_min_bytes=%d", minBytes), func(b *testing.B) {
+			l := newLoop(types.ConnectionConfig{
+				BatchCount:          5,
+				CompressionMinBytes: minBytes,
+			}, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+			defer l.ticker.Stop()
+			l.series = series
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := l.buildRequest(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkShardingSkewed(b *testing.B) {
+	const shards = 8
+	for _, sharding := range []string{types.ShardingHash, types.ShardingRoundRobin} {
+		b.Run(sharding, func(b *testing.B) {
+			strategy := newShardingStrategy(types.ConnectionConfig{
+				Connections: shards,
+				Sharding:    sharding,
+			})
+			// A handful of busy series whose hashes all share the same modulo, along with many quiet series.
+			workload := make([]uint64, 0)
+			for i := uint64(0); i < 4; i++ {
+				for j := 0; j < 1_000; j++ {
+					workload = append(workload, i*shards)
+				}
+			}
+			for i := uint64(0); i < 4_000; i++ {
+				workload = append(workload, rand.Uint64())
+			}
+			fill := make([]int, shards)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fill[strategy.shard(workload[i%len(workload)])]++
+			}
+			b.StopTimer()
+			// Report how full the busiest shard is compared to an even spread.
+			maxFill := slices.Max(fill)
+			b.ReportMetric(float64(maxFill)*shards/float64(b.N), "max/avg")
+		})
+	}
+}
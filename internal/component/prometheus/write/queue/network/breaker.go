@@ -0,0 +1,107 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open, not sending to the endpoint")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// halfOpenWait is how long requests wait while another request probes the endpoint.
+const halfOpenWait = 1 * time.Second
+
+// circuitBreaker stops sending to an endpoint after consecutive recoverable failures across all loops. Once the
+// cooldown passes a single request probes the endpoint, closing the breaker if it succeeds or opening it again if not.
+// It is shared by the loops of a manager.
+type circuitBreaker struct {
+	mut       sync.Mutex
+	threshold uint
+	cooldown  time.Duration
+	failures  uint
+	state     breakerState
+	openedAt  time.Time
+	probing   bool
+	stats     func(types.NetworkStats)
+}
+
+// newCircuitBreaker returns nil if the circuit breaker is disabled.
+func newCircuitBreaker(cc types.ConnectionConfig, stats func(types.NetworkStats)) *circuitBreaker {
+	if cc.CircuitBreakerThreshold == 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: cc.CircuitBreakerThreshold,
+		cooldown:  cc.CircuitBreakerCooldown,
+		stats:     stats,
+	}
+}
+
+// allow returns true if a request can be sent, otherwise it returns how long to wait before trying again.
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+			return false, remaining
+		}
+		b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false, halfOpenWait
+		}
+		b.probing = true
+	}
+	return true, 0
+}
+
+// record records the outcome of a request that was allowed. Only recoverable failures count, a request the endpoint
+// rejected outright means it is up.
+func (b *circuitBreaker) record(failed bool) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.state == breakerClosed && b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// release gives back a request that was allowed but never reached the endpoint, so it records no outcome.
+func (b *circuitBreaker) release() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.probing = false
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	value := int(state)
+	b.stats(types.NetworkStats{
+		CircuitBreakerState: &value,
+	})
+}
@@ -0,0 +1,84 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var states []int
+	b := newCircuitBreaker(types.ConnectionConfig{
+		CircuitBreakerThreshold: 3,
+		CircuitBreakerCooldown:  100 * time.Millisecond,
+	}, func(s types.NetworkStats) {
+		if s.CircuitBreakerState != nil {
+			states = append(states, *s.CircuitBreakerState)
+		}
+	})
+
+	// A success resets the consecutive failures.
+	b.record(true)
+	b.record(true)
+	b.record(false)
+	b.record(true)
+	b.record(true)
+	allowed, _ := b.allow()
+	require.True(t, allowed)
+
+	b.record(true)
+	allowed, wait := b.allow()
+	require.False(t, allowed)
+	require.Greater(t, wait, time.Duration(0))
+	require.LessOrEqual(t, wait, 100*time.Millisecond)
+
+	// After the cooldown a single probe is allowed, a failed probe opens the breaker again.
+	time.Sleep(100 * time.Millisecond)
+	allowed, _ = b.allow()
+	require.True(t, allowed)
+	allowed, wait = b.allow()
+	require.False(t, allowed)
+	require.Equal(t, halfOpenWait, wait)
+	b.record(true)
+	allowed, _ = b.allow()
+	require.False(t, allowed)
+
+	// A successful probe closes it.
+	time.Sleep(100 * time.Millisecond)
+	allowed, _ = b.allow()
+	require.True(t, allowed)
+	b.record(false)
+	allowed, _ = b.allow()
+	require.True(t, allowed)
+
+	require.Equal(t, []int{
+		int(breakerOpen),
+		int(breakerHalfOpen),
+		int(breakerOpen),
+		int(breakerHalfOpen),
+		int(breakerClosed),
+	}, states)
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	require.Nil(t, newCircuitBreaker(types.ConnectionConfig{}, func(s types.NetworkStats) {}))
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	b := newCircuitBreaker(types.ConnectionConfig{
+		CircuitBreakerThreshold: 1,
+		CircuitBreakerCooldown:  100 * time.Millisecond,
+	}, func(s types.NetworkStats) {})
+	b.record(true)
+
+	// A probe that was never sent records nothing, so the next request probes instead.
+	time.Sleep(100 * time.Millisecond)
+	allowed, _ := b.allow()
+	require.True(t, allowed)
+	b.release()
+	allowed, _ = b.allow()
+	require.True(t, allowed)
+	require.Equal(t, breakerHalfOpen, b.state)
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// unhealthyAfterFailures is how many permanent errors in a row mark a replica unhealthy.
+	unhealthyAfterFailures = 3
+	// unhealthyDuration is how long an unhealthy replica is skipped before it is tried again.
+	unhealthyDuration = 30 * time.Second
+)
+
+// endpointPool round robins requests across interchangeable replicas of an endpoint. A replica that keeps returning
+// permanent errors is skipped for a while so batches can be retried on a healthy one. It is shared by the loops of a
+// manager, so every loop skips a replica once it is unhealthy.
+type endpointPool struct {
+	mut            sync.Mutex
+	urls           []string
+	next           int
+	failures       []int
+	unhealthyUntil []time.Time
+}
+
+func newEndpointPool(url string, replicas []string) *endpointPool {
+	urls := append([]string{url}, replicas...)
+	return &endpointPool{
+		urls:           urls,
+		failures:       make([]int, len(urls)),
+		unhealthyUntil: make([]time.Time, len(urls)),
+	}
+}
+
+// pick returns the index and url of the next healthy replica. If every replica is unhealthy the next one is used
+// anyway, dropping data because of a bad health check would be worse than trying.
+func (p *endpointPool) pick() (int, string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	now := time.Now()
+	for range p.urls {
+		i := p.next
+		p.next = (p.next + 1) % len(p.urls)
+		if now.After(p.unhealthyUntil[i]) {
+			return i, p.urls[i]
+		}
+	}
+	i := p.next
+	p.next = (p.next + 1) % len(p.urls)
+	return i, p.urls[i]
+}
+
+// succeeded resets the failures of a replica.
+func (p *endpointPool) succeeded(i int) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	p.failures[i] = 0
+	p.unhealthyUntil[i] = time.Time{}
+}
+
+// failed records a permanent error for a replica. Returns true if the replica was marked unhealthy and another
+// healthy replica is available to retry on.
+func (p *endpointPool) failed(i int) bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	p.failures[i]++
+	if p.failures[i] < unhealthyAfterFailures {
+		return false
+	}
+	now := time.Now()
+	p.unhealthyUntil[i] = now.Add(unhealthyDuration)
+	for j := range p.urls {
+		if j != i && now.After(p.unhealthyUntil[j]) {
+			return true
+		}
+	}
+	return false
+}
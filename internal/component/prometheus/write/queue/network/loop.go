@@ -3,10 +3,13 @@ package network
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -16,9 +19,12 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/snappy"
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/vladopajic/go-actor/actor"
 	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
 )
 
 var _ actor.Worker = (*loop)(nil)
@@ -27,8 +33,12 @@ var _ actor.Worker = (*loop)(nil)
 // loop makes no attempt to save or restore signals in the queue.
 // loop config cannot be updated, it is easier to recreate. This does mean we lose any signals in the queue.
 type loop struct {
-	isMeta         bool
-	seriesMbx      actor.Mailbox[*types.TimeSeriesBinary]
+	isMeta    bool
+	seriesMbx actor.Mailbox[*types.TimeSeriesBinary]
+	// histogramMbx receives histograms separately from samples, they are much larger so they have their own budget.
+	histogramMbx actor.Mailbox[*types.TimeSeriesBinary]
+	// flushMbx receives flush requests.
+	flushMbx       actor.Mailbox[flushRequest]
 	client         *http.Client
 	cfg            types.ConnectionConfig
 	log            log.Logger
@@ -42,22 +52,123 @@ type loop struct {
 	req            *prompb.WriteRequest
 	buf            *proto.Buffer
 	sendBuffer     []byte
+	// zstdEncoder is only set when the compression is zstd.
+	zstdEncoder *zstd.Encoder
+	// gzipWriter is only set when the compression is gzip, it is reset for every request.
+	gzipWriter   *gzip.Writer
+	uncompressed uncompressedBytes
+	// batchCount is the effective batch count, it only differs from the configured one if AdaptiveBatchCount is set.
+	batchCount      int
+	successfulSends int
+	// histogramCount is the number of histograms in series.
+	histogramCount      int
+	histogramBatchCount int
+	// pending is the number of series sent to the mailboxes that have not been received yet.
+	pending atomic.Int64
+	// pendingHistograms is the number of histograms included in pending.
+	pendingHistograms atomic.Int64
+	// histogramSlots holds a slot for every pending histogram, it is nil if MaxPendingHistograms is 0.
+	histogramSlots chan struct{}
+	// flushInterval is the effective flush interval, it only differs from the configured one if AdaptiveFlushInterval is set.
+	flushInterval     time.Duration
+	receivedSinceTick bool
+	idleTicks         int
+	fullBatches       int
+	// dropLabels are label names removed from series before they are sent.
+	dropLabels    map[string]struct{}
+	droppedLabels int
+	// sendLimit is owned by the manager, a slot is held while a request is in flight.
+	sendLimit chan struct{}
+	// breaker is owned by the manager, it is nil if the circuit breaker is disabled.
+	breaker *circuitBreaker
+	// rateLimit is owned by the manager, it is nil if there is no limit or the loop sends metadata.
+	rateLimit *rate.Limiter
+	// lastError is owned by the manager and reported by its Status.
+	lastError *atomic.Pointer[sendError]
+	// errorThrottle is owned by the manager, it is nil if every send error is logged.
+	errorThrottle *errorThrottle
+	// batchCountTotal is owned by the manager, it is the sum of batchCount across the loops that share the stats.
+	batchCountTotal *atomic.Int64
+	// retryableCodes and nonRetryableCodes override which status codes are retried.
+	retryableCodes    map[int]struct{}
+	nonRetryableCodes map[int]struct{}
+	// endpoints is owned by the manager, it holds the url and its replicas that requests are spread across.
+	endpoints *endpointPool
+	// lastTimestamps is the newest timestamp of each series in the batch, it is only set if DetectOutOfOrder is set.
+	lastTimestamps map[uint64]int64
+	outOfOrder     int
+	unsortedLabels int
+	// skippedCompression is true if the send buffer holds a request smaller than CompressionMinBytes, uncompressed.
+	skippedCompression bool
+	// graceEnd is when the StartupGracePeriod is over.
+	graceEnd time.Time
+}
+
+// flushRequest asks a loop to send what it has queued until ctx is done, done is closed once the flush is over.
+type flushRequest struct {
+	ctx  context.Context
+	done chan struct{}
+}
+
+// uncompressedBytes is the size of a write request before compression, attributed to the type of data.
+type uncompressedBytes struct {
+	samples    int
+	histograms int
+	metadata   int
 }
 
 func newLoop(cc types.ConnectionConfig, isMetaData bool, l log.Logger, stats func(s types.NetworkStats)) *loop {
+	histogramBatchCount := cc.HistogramBatchCount
+	if histogramBatchCount <= 0 {
+		histogramBatchCount = cc.BatchCount
+	}
 	// TODO @mattdurham add TLS support afer the initial push.
+	dropLabels := toSet(cc.DropLabels)
+	var lastTimestamps map[uint64]int64
+	if cc.DetectOutOfOrder && !isMetaData {
+		lastTimestamps = make(map[uint64]int64, cc.BatchCount)
+	}
+	var zstdEncoder *zstd.Encoder
+	if cc.Compression == types.CompressionZstd {
+		// Creating an encoder without a writer cannot fail.
+		zstdEncoder, _ = zstd.NewWriter(nil)
+	}
+	var gzipWriter *gzip.Writer
+	if cc.Compression == types.CompressionGzip {
+		gzipWriter = gzip.NewWriter(nil)
+	}
+	// Without a grace period the first tick sends whatever has been received.
+	var lastSend, graceEnd time.Time
+	if cc.StartupGracePeriod > 0 {
+		lastSend = time.Now()
+		graceEnd = lastSend.Add(cc.StartupGracePeriod)
+	}
 	return &loop{
 		isMeta: isMetaData,
 		// In general we want a healthy queue of items, in this case we want to have 2x our maximum send sized ready.
-		seriesMbx:      actor.NewMailbox[*types.TimeSeriesBinary](actor.OptCapacity(2 * cc.BatchCount)),
-		client:         &http.Client{},
-		cfg:            cc,
-		log:            log.With(l, "name", "loop", "url", cc.URL),
-		statsFunc:      stats,
-		externalLabels: cc.ExternalLabels,
-		ticker:         time.NewTicker(1 * time.Second),
-		buf:            proto.NewBuffer(nil),
-		sendBuffer:     make([]byte, 0),
+		seriesMbx:           actor.NewMailbox[*types.TimeSeriesBinary](actor.OptCapacity(2 * cc.BatchCount)),
+		histogramMbx:        actor.NewMailbox[*types.TimeSeriesBinary](actor.OptCapacity(2 * histogramBatchCount)),
+		flushMbx:            actor.NewMailbox[flushRequest](),
+		histogramBatchCount: histogramBatchCount,
+		histogramSlots:      newHistogramSlots(cc),
+		client:              &http.Client{},
+		cfg:                 cc,
+		log:                 log.With(l, "name", "loop", "url", cc.URL),
+		statsFunc:           stats,
+		externalLabels:      cc.ExternalLabels,
+		ticker:              time.NewTicker(1 * time.Second),
+		buf:                 proto.NewBuffer(nil),
+		sendBuffer:          make([]byte, 0),
+		zstdEncoder:         zstdEncoder,
+		gzipWriter:          gzipWriter,
+		batchCount:          cc.BatchCount,
+		flushInterval:       cc.FlushInterval,
+		dropLabels:          dropLabels,
+		retryableCodes:      toSet(cc.RetryableStatusCodes),
+		nonRetryableCodes:   toSet(cc.NonRetryableStatusCodes),
+		lastTimestamps:      lastTimestamps,
+		lastSend:            lastSend,
+		graceEnd:            graceEnd,
 		req: &prompb.WriteRequest{
 			// We know BatchCount is the most we will ever send.
 			Timeseries: make([]prompb.TimeSeries, 0, cc.BatchCount),
@@ -65,6 +176,55 @@ func newLoop(cc types.ConnectionConfig, isMetaData bool, l log.Logger, stats fun
 	}
 }
 
+// newHistogramSlots returns a semaphore for MaxPendingHistograms, or nil if pending histograms are not limited.
+func newHistogramSlots(cc types.ConnectionConfig) chan struct{} {
+	if cc.MaxPendingHistograms <= 0 {
+		return nil
+	}
+	return make(chan struct{}, cc.MaxPendingHistograms)
+}
+
+// reserveHistogram waits for a free slot once MaxPendingHistograms histograms are pending, it returns false if the
+// context is done first.
+func (l *loop) reserveHistogram(ctx context.Context) bool {
+	if l.histogramSlots != nil {
+		select {
+		case l.histogramSlots <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	l.pendingHistograms.Inc()
+	return true
+}
+
+// releaseHistogram frees the slot of a histogram that was received or couldn't be queued.
+func (l *loop) releaseHistogram() {
+	l.pendingHistograms.Dec()
+	if l.histogramSlots != nil {
+		<-l.histogramSlots
+	}
+}
+
+// utilization returns the series waiting in the mailboxes as a multiple of the configured batch count. It isn't
+// capped, the mailboxes are unbounded, so a value above 1 means more than a full batch is waiting.
+func (l *loop) utilization() float64 {
+	if l.cfg.BatchCount <= 0 {
+		return 0
+	}
+	return float64(l.pending.Load()) / float64(l.cfg.BatchCount)
+}
+
+// staggerFlush offsets the first flush of the loop based on its index so that loops spread their
+// initial sends across the FlushStagger window instead of all flushing at once.
+func (l *loop) staggerFlush(index uint) {
+	if l.cfg.FlushStagger <= 0 || l.cfg.Connections == 0 {
+		return
+	}
+	offset := time.Duration(index) * l.cfg.FlushStagger / time.Duration(l.cfg.Connections)
+	l.lastSend = time.Now().Add(offset)
+}
+
 func (l *loop) Start() {
 	l.self = actor.Combine(l.actors()...).Build()
 	l.self.Start()
@@ -79,6 +239,8 @@ func (l *loop) actors() []actor.Actor {
 	return []actor.Actor{
 		actor.New(l),
 		l.seriesMbx,
+		l.histogramMbx,
+		l.flushMbx,
 	}
 }
 
@@ -89,38 +251,229 @@ func (l *loop) DoWork(ctx actor.Context) actor.WorkerStatus {
 		return actor.WorkerEnd
 	// Ticker is to ensure the flush timer is called.
 	case <-l.ticker.C:
+		l.adaptFlushIntervalOnTick()
 		if len(l.series) == 0 {
 			return actor.WorkerContinue
 		}
-		if time.Since(l.lastSend) > l.cfg.FlushInterval {
-			l.trySend(ctx)
+		if l.flushDue() {
+			l.fullBatches = 0
+			l.sendBatch(ctx, types.BatchTriggerTimer)
 		}
 		return actor.WorkerContinue
 	case series, ok := <-l.seriesMbx.ReceiveC():
 		if !ok {
 			return actor.WorkerEnd
 		}
-		l.series = append(l.series, series)
-		if len(l.series) >= l.cfg.BatchCount {
-			l.trySend(ctx)
+		l.add(ctx, series, false)
+		return actor.WorkerContinue
+	case histogram, ok := <-l.histogramMbx.ReceiveC():
+		if !ok {
+			return actor.WorkerEnd
+		}
+		l.add(ctx, histogram, true)
+		return actor.WorkerContinue
+	case req, ok := <-l.flushMbx.ReceiveC():
+		if !ok {
+			return actor.WorkerEnd
 		}
+		// The flush ends when the caller gives up or when the loop is stopped, whichever is first.
+		flushCtx, cncl := context.WithCancel(req.ctx)
+		stop := context.AfterFunc(ctx, cncl)
+		l.flush(flushCtx)
+		stop()
+		cncl()
+		close(req.done)
 		return actor.WorkerContinue
 	}
 }
 
+// add appends a series received from the mailboxes to the batch and sends the batch once it is full.
+func (l *loop) add(ctx context.Context, ts *types.TimeSeriesBinary, isHistogram bool) {
+	l.pending.Dec()
+	l.receivedSinceTick = true
+	l.removeDropLabels(ts)
+	l.sortLabels(ts)
+	l.detectOutOfOrder(ts)
+	l.series = append(l.series, ts)
+	if isHistogram {
+		l.histogramCount++
+		l.releaseHistogram()
+	}
+	if len(l.series) >= l.batchCount || l.histogramCount >= l.histogramBatchCount {
+		l.adaptFlushIntervalOnFullBatch()
+		l.sendBatch(ctx, types.BatchTriggerFull)
+	}
+}
+
+// removeDropLabels removes the labels listed in DropLabels from the series. External labels are added when the
+// request is built so they are never removed.
+func (l *loop) removeDropLabels(ts *types.TimeSeriesBinary) {
+	if l.isMeta || len(l.dropLabels) == 0 {
+		return
+	}
+	before := len(ts.Labels)
+	ts.Labels = slices.DeleteFunc(ts.Labels, func(lbl labels.Label) bool {
+		_, found := l.dropLabels[lbl.Name]
+		return found
+	})
+	l.droppedLabels += before - len(ts.Labels)
+}
+
+// sortLabels sorts the labels of a series that are out of order, remote write requires them to be sorted by name.
+// It is only done if ValidateLabelOrder is set since labels from Prometheus are always sorted.
+func (l *loop) sortLabels(ts *types.TimeSeriesBinary) {
+	if l.isMeta || !l.cfg.ValidateLabelOrder {
+		return
+	}
+	if slices.IsSortedFunc(ts.Labels, compareLabelNames) {
+		return
+	}
+	slices.SortFunc(ts.Labels, compareLabelNames)
+	l.unsortedLabels++
+}
+
+func compareLabelNames(a, b labels.Label) int {
+	return strings.Compare(a.Name, b.Name)
+}
+
+// detectOutOfOrder counts series whose timestamp is older than an earlier sample of the same series in the batch,
+// the first one in a batch is logged. This is only diagnostic, the series is still sent.
+func (l *loop) detectOutOfOrder(ts *types.TimeSeriesBinary) {
+	if l.lastTimestamps == nil {
+		return
+	}
+	last, found := l.lastTimestamps[ts.Hash]
+	if found && ts.TS < last {
+		if l.outOfOrder == 0 {
+			level.Warn(l.log).Log("msg", "series timestamp went back in time within a batch", "labels", ts.Labels.String(), "timestamp", ts.TS, "previous", last)
+		}
+		l.outOfOrder++
+		return
+	}
+	l.lastTimestamps[ts.Hash] = ts.TS
+}
+
+// logSendError logs at debug if the same error was already logged within the LogThrottleInterval.
+func (l *loop) logSendError(err error) {
+	if l.errorThrottle != nil && !l.errorThrottle.allow(err.Error()) {
+		level.Debug(l.log).Log("msg", "error in sending telemetry", "err", err.Error())
+		return
+	}
+	level.Error(l.log).Log("msg", "error in sending telemetry", "err", err.Error())
+}
+
+// flushDue returns true once a partial batch has waited the flush interval since the last send. During the
+// startup grace period it waits at least the grace period, full batches are still sent right away.
+func (l *loop) flushDue() bool {
+	interval := l.flushInterval
+	if time.Now().Before(l.graceEnd) {
+		interval = max(interval, l.cfg.StartupGracePeriod)
+	}
+	return time.Since(l.lastSend) > interval
+}
+
+// adaptiveFlushObservations is how many consecutive idle ticks or full batches are needed to adjust the flush interval.
+const adaptiveFlushObservations = 3
+
+// adaptFlushIntervalOnTick halves the flush interval when a partial batch is waiting and nothing has been received for
+// several ticks, since waiting longer only adds latency.
+func (l *loop) adaptFlushIntervalOnTick() {
+	if !l.cfg.AdaptiveFlushInterval {
+		return
+	}
+	idle := !l.receivedSinceTick
+	l.receivedSinceTick = false
+	if !idle || len(l.series) == 0 {
+		l.idleTicks = 0
+		return
+	}
+	l.idleTicks++
+	if l.idleTicks >= adaptiveFlushObservations {
+		l.idleTicks = 0
+		l.setFlushInterval(max(l.flushInterval/2, l.cfg.MinFlushInterval))
+	}
+}
+
+// adaptFlushIntervalOnFullBatch doubles the flush interval when batches are consistently full, since the timer
+// is not needed to keep latency low.
+func (l *loop) adaptFlushIntervalOnFullBatch() {
+	if !l.cfg.AdaptiveFlushInterval {
+		return
+	}
+	l.fullBatches++
+	if l.fullBatches >= adaptiveFlushObservations {
+		l.fullBatches = 0
+		l.setFlushInterval(min(l.flushInterval*2, l.cfg.MaxFlushInterval))
+	}
+}
+
+func (l *loop) setFlushInterval(interval time.Duration) {
+	if interval == l.flushInterval {
+		return
+	}
+	level.Debug(l.log).Log("msg", "adjusted flush interval", "previous", l.flushInterval, "current", interval)
+	l.flushInterval = interval
+}
+
+// flush receives everything still in the mailboxes and sends it regardless of the batch count.
+func (l *loop) flush(ctx context.Context) {
+	for l.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case series, ok := <-l.seriesMbx.ReceiveC():
+			if !ok {
+				return
+			}
+			l.add(ctx, series, false)
+		case histogram, ok := <-l.histogramMbx.ReceiveC():
+			if !ok {
+				return
+			}
+			l.add(ctx, histogram, true)
+		}
+	}
+	if len(l.series) > 0 {
+		l.sendBatch(ctx, types.BatchTriggerFlush)
+	}
+}
+
+// sendBatch records what triggered sending the batch and sends it.
+func (l *loop) sendBatch(ctx context.Context, trigger string) {
+	l.statsFunc(types.NetworkStats{
+		BatchTrigger: trigger,
+	})
+	l.trySend(ctx)
+}
+
 // trySend is the core functionality for sending data to a endpoint. It will attempt retries as defined in MaxRetryAttempts.
 func (l *loop) trySend(ctx context.Context) {
+	if l.splitIfTooLarge(ctx) {
+		return
+	}
 	attempts := 0
 	for {
+		if !l.waitForRateLimit(ctx) {
+			return
+		}
 		start := time.Now()
 		result := l.send(ctx, attempts)
 		duration := time.Since(start)
 		l.statsFunc(types.NetworkStats{
-			SendDuration: duration,
+			SendDuration:    duration,
+			LowestTimestamp: l.lowestTimestamp(),
+			LabelsDropped:   l.droppedLabels,
+			OutOfOrder:      l.outOfOrder,
+			UnsortedLabels:  l.unsortedLabels,
 		})
+		l.droppedLabels = 0
+		l.outOfOrder = 0
+		l.unsortedLabels = 0
 		if result.err != nil {
-			level.Error(l.log).Log("msg", "error in sending telemetry", "err", result.err.Error())
+			l.logSendError(result.err)
 		}
+		l.adaptBatchCount(result)
+		l.recordLastError(result, attempts)
 		if result.successful {
 			l.sendingCleanup()
 			return
@@ -139,8 +492,157 @@ func (l *loop) trySend(ctx context.Context) {
 		if l.stopCalled.Load() {
 			return
 		}
-		// Sleep between attempts.
-		time.Sleep(result.retryAfter)
+		// There is no point in retrying if every series has aged out while we were retrying.
+		if l.allSeriesExpired() {
+			level.Debug(l.log).Log("msg", "dropping batch since all series are older than the ttl", "attempts", attempts)
+			recordTTLDropped(l.series, l.statsFunc)
+			l.sendingCleanup()
+			return
+		}
+		if result.serverRetryAfter && result.retryAfter > 0 {
+			l.statsFunc(types.NetworkStats{
+				RetryAfter: result.retryAfter,
+			})
+		}
+		// Sleep between attempts, stopping should not have to wait for the backoff.
+		timer := time.NewTimer(result.retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// waitForRateLimit blocks until the rate limit allows sending the batch, this applies backpressure to the queue.
+// Retries count against the limit too. Returns false if the context is done while waiting.
+func (l *loop) waitForRateLimit(ctx context.Context) bool {
+	if l.rateLimit == nil {
+		return true
+	}
+	// The burst fits a full batch, but a reservation larger than the burst would never be allowed.
+	r := l.rateLimit.ReserveN(time.Now(), min(len(l.series), l.rateLimit.Burst()))
+	delay := r.Delay()
+	if delay == 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		r.Cancel()
+		return false
+	case <-timer.C:
+	}
+	l.statsFunc(types.NetworkStats{
+		RateLimited: delay,
+	})
+	return true
+}
+
+// splitIfTooLarge sends the batch as two halves when its compressed request is larger than MaxRequestBytes, this
+// recurses until each request fits. Returns true if the batch was split and sent.
+func (l *loop) splitIfTooLarge(ctx context.Context) bool {
+	if l.cfg.MaxRequestBytes <= 0 || len(l.sendBuffer) > 0 {
+		return false
+	}
+	// If building fails send will build again and report the error.
+	if err := l.buildRequest(); err != nil {
+		l.sendBuffer = l.sendBuffer[:0]
+		return false
+	}
+	if len(l.sendBuffer) <= l.cfg.MaxRequestBytes {
+		return false
+	}
+	if len(l.series) == 1 {
+		level.Warn(l.log).Log("msg", "a single series is larger than max_request_bytes, sending it anyway", "size", len(l.sendBuffer), "max_request_bytes", l.cfg.MaxRequestBytes, "labels", l.series[0].Labels.String())
+		return false
+	}
+	l.statsFunc(types.NetworkStats{
+		RequestSplits: 1,
+	})
+	// Halves are sent in order so samples of a series stay in order.
+	series := l.series
+	half := len(series) / 2
+	l.sendBuffer = l.sendBuffer[:0]
+	l.series = series[:half]
+	l.trySend(ctx)
+	if l.stopCalled.Load() {
+		// Keep what wasn't sent as the batch, like any other batch interrupted by stopping. What is left of the
+		// first half is always its tail, it is empty if the first half was sent or dropped.
+		l.series = series[half-len(l.series):]
+		l.sendBuffer = l.sendBuffer[:0]
+		return true
+	}
+	l.series = series[half:]
+	l.trySend(ctx)
+	return true
+}
+
+// lowestTimestamp returns the timestamp of the oldest series in the batch, metadata has no timestamp so it returns nil.
+func (l *loop) lowestTimestamp() *int64 {
+	if l.isMeta || len(l.series) == 0 {
+		return nil
+	}
+	lowest := l.series[0].TS
+	for _, ts := range l.series[1:] {
+		if ts.TS < lowest {
+			lowest = ts.TS
+		}
+	}
+	return &lowest
+}
+
+// allSeriesExpired returns true if the TTL is set and every series in the batch is older than it.
+// Metadata has no timestamp so it never expires.
+func (l *loop) allSeriesExpired() bool {
+	if l.isMeta || l.cfg.TTL <= 0 || len(l.series) == 0 {
+		return false
+	}
+	for _, ts := range l.series {
+		if time.Since(time.UnixMilli(ts.TS)) <= l.cfg.TTL {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// minAdaptiveBatchCount is the floor the batch count is reduced to.
+	minAdaptiveBatchCount = 1
+	// adaptiveRecoverySends is how many successful sends are needed before the batch count grows again.
+	adaptiveRecoverySends = 10
+)
+
+// adaptBatchCount halves the batch count when the endpoint rejects a request as too large and slowly grows it back
+// towards the configured batch count after sustained success.
+func (l *loop) adaptBatchCount(result sendResult) {
+	if !l.cfg.AdaptiveBatchCount {
+		return
+	}
+	previous := l.batchCount
+	switch {
+	case result.statusCode == http.StatusRequestEntityTooLarge:
+		l.batchCount = max(l.batchCount/2, minAdaptiveBatchCount)
+		l.successfulSends = 0
+	case result.successful && l.batchCount < l.cfg.BatchCount:
+		l.successfulSends++
+		if l.successfulSends >= adaptiveRecoverySends {
+			l.batchCount = min(l.batchCount+max(l.batchCount/10, 1), l.cfg.BatchCount)
+			l.successfulSends = 0
+		}
+	}
+	if previous != l.batchCount {
+		level.Info(l.log).Log("msg", "adjusted batch count", "previous", previous, "current", l.batchCount)
+		// The gauge is shared by every loop, so it reports the sum of their batch counts.
+		total := l.batchCount
+		if l.batchCountTotal != nil {
+			total = int(l.batchCountTotal.Add(int64(l.batchCount - previous)))
+		}
+		l.statsFunc(types.NetworkStats{
+			BatchCount: total,
+		})
 	}
 }
 
@@ -149,52 +651,118 @@ type sendResult struct {
 	successful       bool
 	recoverableError bool
 	retryAfter       time.Duration
+	// serverRetryAfter is true if retryAfter was set by the server.
+	serverRetryAfter bool
 	statusCode       int
 	networkError     bool
+	// buildError is true if the request could not be built, so nothing was sent.
+	buildError bool
+	// circuitOpen is true if the circuit breaker held the request back, so nothing was sent.
+	circuitOpen bool
+	// dryRun is true if the request was built but not sent because DryRun is set.
+	dryRun bool
+}
+
+// sendError is an error that dropped a batch or failed it more than once.
+type sendError struct {
+	err  error
+	time time.Time
+}
+
+// recordLastError keeps the error of a batch that is dropped or retried more than once, a successful send clears it.
+// A single recoverable failure is common enough that it isn't kept.
+func (l *loop) recordLastError(result sendResult, attempts int) {
+	if l.lastError == nil {
+		return
+	}
+	switch {
+	case result.successful:
+		l.lastError.Store(nil)
+	case result.err != nil && (!result.recoverableError || attempts > 0):
+		l.lastError.Store(&sendError{
+			err:  result.err,
+			time: time.Now(),
+		})
+	}
 }
 
 func (l *loop) sendingCleanup() {
 	types.PutTimeSeriesSliceIntoPool(l.series)
 	l.sendBuffer = l.sendBuffer[:0]
+	l.uncompressed = uncompressedBytes{}
+	l.histogramCount = 0
 	l.series = make([]*types.TimeSeriesBinary, 0, l.cfg.BatchCount)
 	l.lastSend = time.Now()
+	// The map is cleared instead of recreated so it keeps its allocation.
+	clear(l.lastTimestamps)
+	// The lowest pending timestamp would stay at the batch that was just sent, so it is reset once nothing is waiting.
+	if !l.isMeta && l.pending.Load() == 0 {
+		var none int64
+		l.statsFunc(types.NetworkStats{
+			LowestTimestamp: &none,
+		})
+	}
 }
 
 // send is the main work loop of the loop.
 func (l *loop) send(ctx context.Context, retryCount int) sendResult {
 	result := sendResult{}
 	defer func() {
-		recordStats(l.series, l.isMeta, l.statsFunc, result, len(l.sendBuffer))
+		recordStats(l.series, l.isMeta, l.statsFunc, result, len(l.sendBuffer), l.uncompressed)
 	}()
 	// Check to see if this is a retry and we can reuse the buffer.
 	// I wonder if we should do this, its possible we are sending things that have exceeded the TTL.
 	if len(l.sendBuffer) == 0 {
-		var data []byte
-		var wrErr error
-		if l.isMeta {
-			data, wrErr = createWriteRequestMetadata(l.log, l.req, l.series, l.buf)
-		} else {
-			data, wrErr = createWriteRequest(l.req, l.series, l.externalLabels, l.buf)
-		}
-		if wrErr != nil {
+		if wrErr := l.buildRequest(); wrErr != nil {
 			result.err = wrErr
 			result.recoverableError = false
+			result.buildError = true
 			return result
 		}
-		l.sendBuffer = snappy.Encode(l.sendBuffer, data)
+	}
+	// In dry run mode the request is built and compressed as usual but never sent.
+	if l.cfg.DryRun {
+		result.successful = true
+		result.dryRun = true
+		return result
 	}
 
-	httpReq, err := http.NewRequest("POST", l.cfg.URL, bytes.NewReader(l.sendBuffer))
+	attempted := false
+	if l.breaker != nil {
+		allowed, wait := l.breaker.allow()
+		if !allowed {
+			result.err = errCircuitOpen
+			result.recoverableError = true
+			result.circuitOpen = true
+			result.retryAfter = wait
+			return result
+		}
+		// Only a request that got an answer, or failed on its own, says whether the endpoint is up. One that wasn't
+		// sent or was cancelled because the loop is stopping just gives the probe back.
+		defer func() {
+			if !attempted {
+				l.breaker.release()
+				return
+			}
+			l.breaker.record(result.recoverableError)
+		}()
+	}
+	endpoint, url := l.endpoints.pick()
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(l.sendBuffer))
 	if err != nil {
 		result.err = err
 		result.recoverableError = true
 		result.networkError = true
 		return result
 	}
-	httpReq.Header.Add("Content-Encoding", "snappy")
+	if encoding := l.contentEncoding(); encoding != "" {
+		httpReq.Header.Add("Content-Encoding", encoding)
+	}
 	httpReq.Header.Set("Content-Type", "application/x-protobuf")
 	httpReq.Header.Set("User-Agent", l.cfg.UserAgent)
-	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if l.cfg.Protocol != types.ProtocolOTLP {
+		httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	}
 	if l.cfg.BasicAuth != nil {
 		httpReq.SetBasicAuth(l.cfg.BasicAuth.Username, l.cfg.BasicAuth.Password)
 	} else if l.cfg.BearerToken != "" {
@@ -204,23 +772,46 @@ func (l *loop) send(ctx context.Context, retryCount int) sendResult {
 	if retryCount > 0 {
 		httpReq.Header.Set("Retry-Attempt", strconv.Itoa(retryCount))
 	}
-	ctx, cncl := context.WithTimeout(ctx, l.cfg.Timeout)
+	for name, value := range l.cfg.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	// Wait for a free slot instead of dropping, this applies backpressure to the queue.
+	if l.sendLimit != nil {
+		select {
+		case l.sendLimit <- struct{}{}:
+		case <-ctx.Done():
+			result.err = ctx.Err()
+			result.networkError = true
+			result.recoverableError = true
+			return result
+		}
+		defer func() { <-l.sendLimit }()
+	}
+	l.statsFunc(types.NetworkStats{InFlightSends: 1})
+	defer l.statsFunc(types.NetworkStats{InFlightSends: -1})
+	reqCtx, cncl := context.WithTimeout(ctx, l.cfg.Timeout)
 	defer cncl()
-	resp, err := l.client.Do(httpReq.WithContext(ctx))
+	resp, err := l.client.Do(httpReq.WithContext(withConnectionTrace(reqCtx, l.statsFunc)))
+	attempted = ctx.Err() == nil
 	// Network errors are recoverable.
 	if err != nil {
 		result.err = err
 		result.networkError = true
 		result.recoverableError = true
-		result.retryAfter = l.cfg.RetryBackoff
+		result.retryAfter = l.retryBackoff()
 		return result
 	}
 	result.statusCode = resp.StatusCode
 	defer resp.Body.Close()
 	// 500 errors are considered recoverable.
-	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+	if l.isRetryable(resp.StatusCode) {
 		result.err = fmt.Errorf("server responded with status code %d", resp.StatusCode)
-		result.retryAfter = retryAfterDuration(l.cfg.RetryBackoff, resp.Header.Get("Retry-After"))
+		if retryAfter, found := parseRetryAfter(resp.Header.Get("Retry-After")); found {
+			result.retryAfter = retryAfter
+			result.serverRetryAfter = true
+		} else {
+			result.retryAfter = l.retryBackoff()
+		}
 		result.recoverableError = true
 		return result
 	}
@@ -232,14 +823,83 @@ func (l *loop) send(ctx context.Context, retryCount int) sendResult {
 			line = scanner.Text()
 		}
 		result.err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+		// Retry on another replica once this one keeps failing.
+		if l.endpoints.failed(endpoint) {
+			level.Warn(l.log).Log("msg", "marking replica unhealthy", "replica", url, "duration", unhealthyDuration)
+			result.recoverableError = true
+			result.retryAfter = l.retryBackoff()
+		}
 		return result
 	}
 
+	l.endpoints.succeeded(endpoint)
 	result.successful = true
 	return result
 }
 
-func createWriteRequest(wr *prompb.WriteRequest, series []*types.TimeSeriesBinary, externalLabels map[string]string, data *proto.Buffer) ([]byte, error) {
+// buildRequest creates the write request for the series and compresses it into the send buffer.
+func (l *loop) buildRequest() error {
+	var data []byte
+	var err error
+	if l.isMeta {
+		data, err = createWriteRequestMetadata(l.log, l.req, l.series, l.buf)
+		l.uncompressed = uncompressedBytes{metadata: len(data)}
+	} else if l.cfg.Protocol == types.ProtocolOTLP {
+		data, l.uncompressed, err = createOTLPRequest(l.series, l.externalLabels)
+	} else {
+		data, l.uncompressed, err = createWriteRequest(l.req, l.series, l.externalLabels, l.buf)
+	}
+	if err != nil {
+		return err
+	}
+	// Small requests barely shrink, so they are sent as is when CompressionMinBytes is set.
+	l.skippedCompression = len(data) < l.cfg.CompressionMinBytes
+	if l.skippedCompression {
+		// data belongs to the proto buffer which is reset on the next request, so it has to be copied.
+		l.sendBuffer = append(l.sendBuffer[:0], data...)
+		return nil
+	}
+	l.sendBuffer = l.compress(data)
+	return nil
+}
+
+// compress encodes data with the configured codec, reusing the send buffer.
+func (l *loop) compress(data []byte) []byte {
+	switch l.cfg.Compression {
+	case types.CompressionZstd:
+		return l.zstdEncoder.EncodeAll(data, l.sendBuffer[:0])
+	case types.CompressionGzip:
+		buf := bytes.NewBuffer(l.sendBuffer[:0])
+		l.gzipWriter.Reset(buf)
+		// Writing to a bytes.Buffer cannot fail.
+		_, _ = l.gzipWriter.Write(data)
+		_ = l.gzipWriter.Close()
+		return buf.Bytes()
+	case types.CompressionNone:
+		// data belongs to the proto buffer which is reset on the next request, so it has to be copied.
+		return append(l.sendBuffer[:0], data...)
+	default:
+		return snappy.Encode(l.sendBuffer, data)
+	}
+}
+
+func (l *loop) contentEncoding() string {
+	if l.skippedCompression {
+		return ""
+	}
+	switch l.cfg.Compression {
+	case types.CompressionZstd:
+		return "zstd"
+	case types.CompressionGzip:
+		return "gzip"
+	case types.CompressionNone:
+		return ""
+	default:
+		return "snappy"
+	}
+}
+
+func createWriteRequest(wr *prompb.WriteRequest, series []*types.TimeSeriesBinary, externalLabels map[string]string, data *proto.Buffer) ([]byte, uncompressedBytes, error) {
 	if cap(wr.Timeseries) < len(series) {
 		wr.Timeseries = make([]prompb.TimeSeries, len(series))
 	}
@@ -300,6 +960,7 @@ func createWriteRequest(wr *prompb.WriteRequest, series []*types.TimeSeriesBinar
 		ts.Samples[0].Timestamp = tsBuf.TS
 		wr.Timeseries[i] = ts
 	}
+	sizes := estimateSizes(wr)
 	defer func() {
 		for i := 0; i < len(wr.Timeseries); i++ {
 			wr.Timeseries[i].Histograms = wr.Timeseries[i].Histograms[:0]
@@ -310,7 +971,34 @@ func createWriteRequest(wr *prompb.WriteRequest, series []*types.TimeSeriesBinar
 	// Reset the buffer for reuse.
 	data.Reset()
 	err := data.Marshal(wr)
-	return data.Bytes(), err
+	return data.Bytes(), sizes, err
+}
+
+// estimateSizes attributes the marshaled size of each series to either samples or histograms. Since a write request
+// built from series only contains the repeated timeseries field, the sum is the exact uncompressed size. The split is
+// an estimate in that the labels of a series are attributed to whichever type the series carries.
+func estimateSizes(wr *prompb.WriteRequest) uncompressedBytes {
+	var sizes uncompressedBytes
+	for i := range wr.Timeseries {
+		n := wr.Timeseries[i].Size()
+		// Each repeated entry is prefixed with its field tag and a varint length.
+		n += 1 + varintSize(uint64(n))
+		if len(wr.Timeseries[i].Histograms) > 0 {
+			sizes.histograms += n
+		} else {
+			sizes.samples += n
+		}
+	}
+	return sizes
+}
+
+func varintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
 }
 
 func createWriteRequestMetadata(l log.Logger, wr *prompb.WriteRequest, series []*types.TimeSeriesBinary, data *proto.Buffer) ([]byte, error) {
@@ -358,14 +1046,43 @@ func toMetadata(ts *types.TimeSeriesBinary) (prompb.MetricMetadata, bool) {
 	}, true
 }
 
-func retryAfterDuration(defaultDuration time.Duration, t string) time.Duration {
+// retryBackoff returns how long to wait before retrying, with full jitter applied if RetryJitter is set.
+func (l *loop) retryBackoff() time.Duration {
+	if !l.cfg.RetryJitter || l.cfg.RetryBackoff <= 0 {
+		return l.cfg.RetryBackoff
+	}
+	return time.Duration(rand.Int63n(int64(l.cfg.RetryBackoff) + 1))
+}
+
+// isRetryable returns true if a response with the status code should be retried. The configured status codes take
+// precedence, otherwise 5xx and 429 are retried.
+func (l *loop) isRetryable(code int) bool {
+	if _, found := l.retryableCodes[code]; found {
+		return true
+	}
+	if _, found := l.nonRetryableCodes[code]; found {
+		return false
+	}
+	return code/100 == 5 || code == http.StatusTooManyRequests
+}
+
+func toSet[T comparable](items []T) map[T]struct{} {
+	set := make(map[T]struct{}, len(items))
+	for _, item := range items {
+		set[item] = struct{}{}
+	}
+	return set
+}
+
+// parseRetryAfter returns the duration of a Retry-After header and whether it could be parsed.
+func parseRetryAfter(t string) (time.Duration, bool) {
 	if parsedTime, err := time.Parse(http.TimeFormat, t); err == nil {
-		return time.Until(parsedTime)
+		return time.Until(parsedTime), true
 	}
 	// The duration can be in seconds.
 	d, err := strconv.Atoi(t)
 	if err != nil {
-		return defaultDuration
+		return 0, false
 	}
-	return time.Duration(d) * time.Second
+	return time.Duration(d) * time.Second, true
 }
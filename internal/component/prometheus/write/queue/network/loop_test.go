@@ -0,0 +1,231 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/protobuf/proto"
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUncompressedSizes(t *testing.T) {
+	series := make([]*types.TimeSeriesBinary, 0)
+	for i := 0; i < 10; i++ {
+		series = append(series, createSeries(t))
+	}
+	hist := createSeries(t)
+	hist.FromHistogram(time.Now().UnixMilli(), &histogram.Histogram{
+		Count:           5,
+		Sum:             10,
+		Schema:          2,
+		PositiveSpans:   []histogram.Span{{Offset: 1, Length: 2}},
+		PositiveBuckets: []int64{1, 2},
+	})
+	series = append(series, hist)
+
+	wr := &prompb.WriteRequest{}
+	data, sizes, err := createWriteRequest(wr, series, map[string]string{"cluster": "test"}, proto.NewBuffer(nil))
+	require.NoError(t, err)
+	require.True(t, sizes.samples > 0)
+	require.True(t, sizes.histograms > 0)
+	require.Zero(t, sizes.metadata)
+	// The estimate is exact in total.
+	require.Equal(t, len(data), sizes.samples+sizes.histograms)
+}
+
+func TestRetryJitter(t *testing.T) {
+	cc := types.ConnectionConfig{
+		BatchCount:   10,
+		RetryBackoff: 1 * time.Second,
+	}
+	l := newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	require.Equal(t, time.Second, l.retryBackoff())
+
+	cc.RetryJitter = true
+	l = newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 100; i++ {
+		backoff := l.retryBackoff()
+		require.LessOrEqual(t, backoff, time.Second)
+		require.GreaterOrEqual(t, backoff, time.Duration(0))
+		seen[backoff] = struct{}{}
+	}
+	require.Greater(t, len(seen), 1)
+}
+
+func TestAdaptiveFlushInterval(t *testing.T) {
+	cc := types.ConnectionConfig{
+		BatchCount:            10,
+		FlushInterval:         4 * time.Second,
+		AdaptiveFlushInterval: true,
+		MinFlushInterval:      1 * time.Second,
+		MaxFlushInterval:      8 * time.Second,
+	}
+	l := newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+
+	// A partial batch with nothing arriving shortens the interval down to the minimum.
+	l.series = append(l.series, createSeries(t))
+	for i := 0; i < adaptiveFlushObservations; i++ {
+		l.adaptFlushIntervalOnTick()
+	}
+	require.Equal(t, 2*time.Second, l.flushInterval)
+	for i := 0; i < 3*adaptiveFlushObservations; i++ {
+		l.adaptFlushIntervalOnTick()
+	}
+	require.Equal(t, 1*time.Second, l.flushInterval)
+
+	// Receiving series resets the idle ticks.
+	for i := 0; i < adaptiveFlushObservations-1; i++ {
+		l.adaptFlushIntervalOnTick()
+	}
+	l.receivedSinceTick = true
+	l.adaptFlushIntervalOnTick()
+	require.Equal(t, 1*time.Second, l.flushInterval)
+	require.Zero(t, l.idleTicks)
+
+	// Consistently full batches lengthen the interval up to the maximum.
+	for i := 0; i < 10*adaptiveFlushObservations; i++ {
+		l.adaptFlushIntervalOnFullBatch()
+	}
+	require.Equal(t, 8*time.Second, l.flushInterval)
+}
+
+func TestStartupGracePeriod(t *testing.T) {
+	cc := types.ConnectionConfig{
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+	}
+	l := newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	// Without a grace period the first tick sends.
+	require.True(t, l.flushDue())
+
+	cc.StartupGracePeriod = 1 * time.Minute
+	l = newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	require.False(t, l.flushDue())
+	// Past the flush interval but still within the grace period.
+	l.lastSend = time.Now().Add(-30 * time.Second)
+	require.False(t, l.flushDue())
+
+	// Once the grace period is over the flush interval applies.
+	l.graceEnd = time.Now()
+	require.True(t, l.flushDue())
+}
+
+func TestSortLabels(t *testing.T) {
+	cc := types.ConnectionConfig{
+		BatchCount:         10,
+		ValidateLabelOrder: true,
+	}
+	l := newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+
+	sorted := &types.TimeSeriesBinary{Labels: labels.FromStrings("a", "1", "b", "2")}
+	l.sortLabels(sorted)
+	require.Zero(t, l.unsortedLabels)
+
+	unsorted := &types.TimeSeriesBinary{Labels: labels.Labels{{Name: "job", Value: "test"}, {Name: "__name__", Value: "up"}}}
+	l.sortLabels(unsorted)
+	require.Equal(t, labels.FromStrings("__name__", "up", "job", "test"), unsorted.Labels)
+	require.Equal(t, 1, l.unsortedLabels)
+
+	// Nothing is checked unless it is enabled.
+	cc.ValidateLabelOrder = false
+	l = newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	unsorted = &types.TimeSeriesBinary{Labels: labels.Labels{{Name: "job", Value: "test"}, {Name: "__name__", Value: "up"}}}
+	l.sortLabels(unsorted)
+	require.Equal(t, "job", unsorted.Labels[0].Name)
+	require.Zero(t, l.unsortedLabels)
+}
+
+func TestDetectOutOfOrder(t *testing.T) {
+	cc := types.ConnectionConfig{
+		BatchCount:       10,
+		DetectOutOfOrder: true,
+	}
+	l := newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	for _, ts := range []int64{10, 20, 15, 30} {
+		l.detectOutOfOrder(&types.TimeSeriesBinary{Hash: 1, TS: ts})
+	}
+	// Other series don't affect each other.
+	l.detectOutOfOrder(&types.TimeSeriesBinary{Hash: 2, TS: 5})
+	require.Equal(t, 1, l.outOfOrder)
+
+	// Tracking starts over with the next batch.
+	l.sendingCleanup()
+	require.Empty(t, l.lastTimestamps)
+	l.detectOutOfOrder(&types.TimeSeriesBinary{Hash: 1, TS: 10})
+	require.Equal(t, 1, l.outOfOrder)
+}
+
+func TestUnhealthyReplicaRetryBackoff(t *testing.T) {
+	svr := httptest.NewServer(handler(t, http.StatusBadRequest, func(wr *prompb.WriteRequest) {}))
+	defer svr.Close()
+	cc := types.ConnectionConfig{
+		URL:          svr.URL,
+		ReplicaURLs:  []string{svr.URL},
+		Timeout:      1 * time.Second,
+		BatchCount:   10,
+		RetryBackoff: 1 * time.Second,
+	}
+	l := newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	l.client = svr.Client()
+	l.endpoints = newEndpointPool(cc.URL, cc.ReplicaURLs)
+	l.series = append(l.series, createSeries(t))
+
+	// Requests alternate between the two replicas until one of them is marked unhealthy.
+	var result sendResult
+	for i := 0; i < 2*unhealthyAfterFailures && !result.recoverableError; i++ {
+		result = l.send(context.Background(), i)
+	}
+	require.True(t, result.recoverableError)
+	// The batch waits before it is retried on the other replica instead of retrying right away.
+	require.Equal(t, time.Second, result.retryAfter)
+}
+
+func TestSplitStopKeepsUnsent(t *testing.T) {
+	var l *loop
+	sent := 0
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		sent += len(wr.Timeseries)
+		// Stopping while the first half is sent.
+		l.stopCalled.Store(true)
+	}))
+	defer svr.Close()
+	cc := types.ConnectionConfig{
+		URL:             svr.URL,
+		Timeout:         1 * time.Second,
+		BatchCount:      10,
+		MaxRequestBytes: 1,
+	}
+	l = newLoop(cc, false, log.NewNopLogger(), func(s types.NetworkStats) {})
+	defer l.ticker.Stop()
+	l.client = svr.Client()
+	l.endpoints = newEndpointPool(cc.URL, cc.ReplicaURLs)
+	series := make([]*types.TimeSeriesBinary, 0, 4)
+	for i := 0; i < 4; i++ {
+		series = append(series, createSeries(t))
+	}
+	l.series = append(l.series, series...)
+
+	l.trySend(context.Background())
+	require.Equal(t, 1, sent)
+	// The first quarter is sent, everything after it is kept in the batch.
+	require.Equal(t, series[1:], l.series)
+	require.Empty(t, l.sendBuffer)
+}
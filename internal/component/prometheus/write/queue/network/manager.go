@@ -2,37 +2,84 @@ package network
 
 import (
 	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/go-kit/log"
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
 	"github.com/grafana/alloy/internal/runtime/logging/level"
 	"github.com/vladopajic/go-actor/actor"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
 )
 
 // manager manages loops. Mostly it exists to control their lifecycle and send work to them.
 type manager struct {
-	loops       []*loop
-	metadata    *loop
-	logger      log.Logger
-	inbox       actor.Mailbox[*types.TimeSeriesBinary]
-	metaInbox   actor.Mailbox[*types.TimeSeriesBinary]
-	configInbox actor.Mailbox[configCallback]
-	self        actor.Actor
-	cfg         types.ConnectionConfig
-	stats       func(types.NetworkStats)
-	metaStats   func(types.NetworkStats)
+	// loopsMut guards replacing loops and metadata so Status can read them, the manager itself doesn't need it
+	// since it is the only writer.
+	loopsMut sync.RWMutex
+	loops    []*loop
+	metadata []*loop
+	// nextMetadata is the metadata loop the next metadata is sent to.
+	nextMetadata int
+	logger       log.Logger
+	inbox        actor.Mailbox[*types.TimeSeriesBinary]
+	metaInbox    actor.Mailbox[*types.TimeSeriesBinary]
+	configInbox  actor.Mailbox[configCallback]
+	flushInbox   actor.Mailbox[flushCallback]
+	// pending is the number of series and metadata sent to the inboxes that have not been received yet.
+	pending   atomic.Int64
+	self      actor.Actor
+	cfg       types.ConnectionConfig
+	stats     func(types.NetworkStats)
+	metaStats func(types.NetworkStats)
+	sharding  shardingStrategy
+	// sendLimit is shared with the loops to limit the requests in flight, it is nil if there is no limit.
+	sendLimit chan struct{}
+	// breaker is shared with the loops, it is nil if the circuit breaker is disabled.
+	breaker *circuitBreaker
+	// rateLimit is shared with the series loops, it is nil if there is no limit.
+	rateLimit *rate.Limiter
+	// lastError is shared with the loops, which set it when a send keeps failing and clear it when one succeeds.
+	lastError atomic.Pointer[sendError]
+	// endpoints is shared with the loops so a replica marked unhealthy by one is skipped by all of them.
+	endpoints *endpointPool
+	// client is shared with the loops so their connections are pooled together.
+	client *http.Client
+	// errorThrottle is shared with the loops, it is nil if every send error is logged.
+	errorThrottle *errorThrottle
+	// batchCount and metaBatchCount are shared with the series and metadata loops, they hold the sum of the batch
+	// counts of the loops which adapt them if AdaptiveBatchCount is set.
+	batchCount     atomic.Int64
+	metaBatchCount atomic.Int64
+	// utilizationTicker controls how often the queue utilization is reported.
+	utilizationTicker *time.Ticker
 }
 
+const utilizationInterval = 5 * time.Second
+
 // configCallback allows actors to notify via `done` channel when they're done processing the config `cc`. Useful when synchronous processing is required.
 type configCallback struct {
 	cc   types.ConnectionConfig
 	done chan struct{}
 }
 
+// flushCallback allows the manager to notify via `done` when all the loops have been flushed.
+type flushCallback struct {
+	ctx  context.Context
+	done chan error
+}
+
 var _ types.NetworkClient = (*manager)(nil)
 
 var _ actor.Worker = (*manager)(nil)
 
 func New(cc types.ConnectionConfig, logger log.Logger, seriesStats, metadataStats func(types.NetworkStats)) (types.NetworkClient, error) {
+	if err := cc.Validate(); err != nil {
+		return nil, err
+	}
 	s := &manager{
 		loops:  make([]*loop, 0, cc.Connections),
 		logger: logger,
@@ -41,26 +88,87 @@ func New(cc types.ConnectionConfig, logger log.Logger, seriesStats, metadataStat
 		inbox:       actor.NewMailbox[*types.TimeSeriesBinary](actor.OptCapacity(1)),
 		metaInbox:   actor.NewMailbox[*types.TimeSeriesBinary](actor.OptCapacity(1)),
 		configInbox: actor.NewMailbox[configCallback](),
+		flushInbox:  actor.NewMailbox[flushCallback](),
 		stats:       seriesStats,
 		metaStats:   metadataStats,
 		cfg:         cc,
+		sharding:    newShardingStrategy(cc),
+		sendLimit:   newSendLimit(cc),
+		breaker:     newCircuitBreaker(cc, seriesStats),
+		rateLimit:   newRateLimit(cc),
+		endpoints:   newEndpointPool(cc.URL, cc.ReplicaURLs),
+		client:      newHTTPClient(cc),
+
+		errorThrottle:     newErrorThrottle(cc),
+		utilizationTicker: time.NewTicker(utilizationInterval),
 	}
 
 	// start kicks off a number of concurrent connections.
 	for i := uint(0); i < s.cfg.Connections; i++ {
-		l := newLoop(cc, false, logger, seriesStats)
+		l := newLoop(cc, false, logger, connectionStats(cc, i, seriesStats))
 		l.self = actor.New(l)
+		l.staggerFlush(i)
 		s.loops = append(s.loops, l)
 	}
 
-	s.metadata = newLoop(cc, true, logger, metadataStats)
-	s.metadata.self = actor.New(s.metadata)
+	s.metadata = newMetadataLoops(cc, logger, metadataStats)
 	return s, nil
 }
 
+// newSendLimit returns a semaphore for MaxConcurrentSends, or nil if sends are not limited.
+func newSendLimit(cc types.ConnectionConfig) chan struct{} {
+	if cc.MaxConcurrentSends <= 0 {
+		return nil
+	}
+	return make(chan struct{}, cc.MaxConcurrentSends)
+}
+
+// newHTTPClient returns a client that keeps an idle connection for every loop. The default transport only keeps two per
+// host, so when more loops than that send at once the rest open new connections.
+func newHTTPClient(cc types.ConnectionConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = int(cc.Connections + max(cc.MetadataParallelism, 1))
+	transport.MaxIdleConns = max(transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	return &http.Client{Transport: transport}
+}
+
+// newRateLimit returns a token bucket for MaxSamplesPerSecond, or nil if samples are not limited. The burst allows a
+// full batch to be sent at once.
+func newRateLimit(cc types.ConnectionConfig) *rate.Limiter {
+	if cc.MaxSamplesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(cc.MaxSamplesPerSecond), max(cc.MaxSamplesPerSecond, cc.BatchCount))
+}
+
+// connectionStats labels the stats of a loop with its index if PerConnectionMetrics is enabled.
+func connectionStats(cc types.ConnectionConfig, i uint, stats func(types.NetworkStats)) func(types.NetworkStats) {
+	if !cc.PerConnectionMetrics {
+		return stats
+	}
+	connection := strconv.FormatUint(uint64(i), 10)
+	return func(s types.NetworkStats) {
+		s.Connection = connection
+		stats(s)
+	}
+}
+
+// newMetadataLoops creates the metadata loops, metadata ordering does not matter so they are sent to in turn.
+func newMetadataLoops(cc types.ConnectionConfig, logger log.Logger, metadataStats func(types.NetworkStats)) []*loop {
+	count := max(cc.MetadataParallelism, 1)
+	loops := make([]*loop, 0, count)
+	for i := uint(0); i < count; i++ {
+		l := newLoop(cc, true, logger, metadataStats)
+		l.self = actor.New(l)
+		loops = append(loops, l)
+	}
+	return loops
+}
+
 func (s *manager) Start() {
 	s.startLoops()
 	s.configInbox.Start()
+	s.flushInbox.Start()
 	s.metaInbox.Start()
 	s.inbox.Start()
 	s.self = actor.New(s)
@@ -68,14 +176,27 @@ func (s *manager) Start() {
 }
 
 func (s *manager) SendSeries(ctx context.Context, data *types.TimeSeriesBinary) error {
-	return s.inbox.Send(ctx, data)
+	s.pending.Inc()
+	err := s.inbox.Send(ctx, data)
+	if err != nil {
+		s.pending.Dec()
+	}
+	return err
 }
 
 func (s *manager) SendMetadata(ctx context.Context, data *types.TimeSeriesBinary) error {
-	return s.metaInbox.Send(ctx, data)
+	s.pending.Inc()
+	err := s.metaInbox.Send(ctx, data)
+	if err != nil {
+		s.pending.Dec()
+	}
+	return err
 }
 
 func (s *manager) UpdateConfig(ctx context.Context, cc types.ConnectionConfig) error {
+	if err := cc.Validate(); err != nil {
+		return err
+	}
 	done := make(chan struct{})
 	defer close(done)
 	err := s.configInbox.Send(ctx, configCallback{
@@ -89,6 +210,26 @@ func (s *manager) UpdateConfig(ctx context.Context, cc types.ConnectionConfig) e
 	return nil
 }
 
+// flushAndWait sends all queued series and metadata, it returns once they are sent or the context is done. It is only
+// used by StopWithTimeout, a flush while running would hold up everything else the manager does.
+func (s *manager) flushAndWait(ctx context.Context) error {
+	// Buffered so the manager never blocks if the caller has given up.
+	done := make(chan error, 1)
+	err := s.flushInbox.Send(ctx, flushCallback{
+		ctx:  ctx,
+		done: done,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s *manager) DoWork(ctx actor.Context) actor.WorkerStatus {
 	// This acts as a priority queue, always check for configuration changes first.
 	select {
@@ -114,17 +255,26 @@ func (s *manager) DoWork(ctx actor.Context) actor.WorkerStatus {
 			level.Debug(s.logger).Log("msg", "series inbox closed")
 			return actor.WorkerEnd
 		}
+		s.pending.Dec()
 		s.queue(ctx, ts)
 		return actor.WorkerContinue
+	case cb, ok := <-s.flushInbox.ReceiveC():
+		if !ok {
+			level.Debug(s.logger).Log("msg", "flush inbox closed")
+			return actor.WorkerEnd
+		}
+		cb.done <- s.flush(cb.ctx)
+		return actor.WorkerContinue
+	case <-s.utilizationTicker.C:
+		s.reportUtilization()
+		return actor.WorkerContinue
 	case ts, ok := <-s.metaInbox.ReceiveC():
 		if !ok {
 			level.Debug(s.logger).Log("msg", "meta inbox closed")
 			return actor.WorkerEnd
 		}
-		err := s.metadata.seriesMbx.Send(ctx, ts)
-		if err != nil {
-			level.Error(s.logger).Log("msg", "failed to send to metadata loop", "err", err)
-		}
+		s.pending.Dec()
+		s.queueMetadata(ctx, ts)
 		return actor.WorkerContinue
 		// We need to also check the config here, else its possible this will deadlock.
 	case cfg, ok := <-s.configInbox.ReceiveC():
@@ -145,55 +295,255 @@ func (s *manager) updateConfig(cc types.ConnectionConfig) {
 		return
 	}
 	s.cfg = cc
+	s.sharding = newShardingStrategy(cc)
+	s.sendLimit = newSendLimit(cc)
+	s.breaker = newCircuitBreaker(cc, s.stats)
+	s.rateLimit = newRateLimit(cc)
+	s.endpoints = newEndpointPool(cc.URL, cc.ReplicaURLs)
+	s.errorThrottle = newErrorThrottle(cc)
 	// TODO @mattdurham make this smarter, at the moment any samples in the loops are lost.
 	// Ideally we would drain the queues and re add them but that is a future need.
 	// In practice this shouldn't change often so data loss should be minimal.
 	// For the moment we will stop all the items and recreate them.
 	level.Debug(s.logger).Log("msg", "dropping all series in loops and creating queue due to config change")
 	s.stopLoops()
-	s.loops = make([]*loop, 0, s.cfg.Connections)
+	s.client.CloseIdleConnections()
+	s.client = newHTTPClient(cc)
+	loops := make([]*loop, 0, s.cfg.Connections)
 	for i := uint(0); i < s.cfg.Connections; i++ {
-		l := newLoop(cc, false, s.logger, s.stats)
+		l := newLoop(cc, false, s.logger, connectionStats(cc, i, s.stats))
 		l.self = actor.New(l)
-		s.loops = append(s.loops, l)
+		l.staggerFlush(i)
+		loops = append(loops, l)
 	}
 
-	s.metadata = newLoop(cc, true, s.logger, s.metaStats)
-	s.metadata.self = actor.New(s.metadata)
+	metadata := newMetadataLoops(cc, s.logger, s.metaStats)
+	s.loopsMut.Lock()
+	s.loops = loops
+	s.metadata = metadata
+	s.loopsMut.Unlock()
+	s.nextMetadata = 0
 	level.Debug(s.logger).Log("msg", "starting loops")
 	s.startLoops()
 	level.Debug(s.logger).Log("msg", "loops started")
 }
 
 func (s *manager) Stop() {
-	s.stopLoops()
+	s.utilizationTicker.Stop()
+	// The manager is stopped before the loops, it may still be flushing or queueing to them and sending to a stopped
+	// mailbox panics.
 	s.configInbox.Stop()
+	s.flushInbox.Stop()
 	s.metaInbox.Stop()
 	s.inbox.Stop()
 	s.self.Stop()
+	s.stopLoops()
+	s.client.CloseIdleConnections()
+}
+
+func (s *manager) Status() types.NetworkStatus {
+	s.loopsMut.RLock()
+	defer s.loopsMut.RUnlock()
+	status := types.NetworkStatus{
+		Connections:         len(s.loops),
+		MetadataConnections: len(s.metadata),
+		Unrouted:            s.pending.Load(),
+	}
+	for _, l := range s.loops {
+		status.PendingSeries += l.pending.Load()
+	}
+	// The loops are replaced along with the config, so their config matches the rest of the status.
+	if len(s.loops) > 0 {
+		status.Protocol = s.loops[0].cfg.Protocol
+		status.Compression = s.loops[0].cfg.Compression
+	}
+	for _, l := range s.metadata {
+		status.PendingMetadata += l.pending.Load()
+	}
+	if last := s.lastError.Load(); last != nil {
+		status.LastError = last.err
+		status.LastErrorTime = last.time
+	}
+	return status
+}
+
+func (s *manager) StopWithTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		s.Stop()
+		return
+	}
+	ctx, cncl := context.WithTimeout(context.Background(), timeout)
+	defer cncl()
+	if err := s.flushAndWait(ctx); err != nil {
+		level.Warn(s.logger).Log("msg", "stopping before all queued series were sent", "timeout", timeout, "err", err)
+	}
+	s.Stop()
 }
 
 func (s *manager) stopLoops() {
 	for _, l := range s.loops {
 		l.Stop()
 	}
-	s.metadata.Stop()
+	for _, l := range s.metadata {
+		l.Stop()
+	}
 }
 
 func (s *manager) startLoops() {
+	s.resetBatchCount(s.loops, &s.batchCount, s.stats)
+	s.resetBatchCount(s.metadata, &s.metaBatchCount, s.metaStats)
 	for _, l := range s.loops {
+		l.sendLimit = s.sendLimit
+		l.breaker = s.breaker
+		l.rateLimit = s.rateLimit
+		l.endpoints = s.endpoints
+		l.lastError = &s.lastError
+		l.client = s.client
+		l.errorThrottle = s.errorThrottle
+		l.Start()
+	}
+	for _, l := range s.metadata {
+		l.sendLimit = s.sendLimit
+		l.breaker = s.breaker
+		l.endpoints = s.endpoints
+		l.lastError = &s.lastError
+		l.client = s.client
+		l.errorThrottle = s.errorThrottle
 		l.Start()
 	}
-	s.metadata.Start()
+}
+
+// resetBatchCount sets the sum of the batch counts of the loops, which haven't been started yet, and publishes it.
+// It is only published if AdaptiveBatchCount is set since otherwise it never changes.
+func (s *manager) resetBatchCount(loops []*loop, total *atomic.Int64, stats func(types.NetworkStats)) {
+	var sum int
+	for _, l := range loops {
+		sum += l.batchCount
+		l.batchCountTotal = total
+	}
+	total.Store(int64(sum))
+	if s.cfg.AdaptiveBatchCount && sum > 0 {
+		stats(types.NetworkStats{
+			BatchCount: sum,
+		})
+	}
 }
 
 // Queue adds anything thats not metadata to the queue.
 func (s *manager) queue(ctx context.Context, ts *types.TimeSeriesBinary) {
 	// Based on a hash which is the label hash add to the queue.
-	queueNum := ts.Hash % uint64(s.cfg.Connections)
+	queueNum := s.sharding.shard(ts.Hash)
 	// This will block if the queue is full.
-	err := s.loops[queueNum].seriesMbx.Send(ctx, ts)
+	l := s.loops[queueNum]
+	mbx := l.seriesMbx
+	isHistogram := ts.IsHistogram()
+	if isHistogram {
+		mbx = l.histogramMbx
+		// Wait for the loop to receive histograms once its budget is used up, this applies backpressure to the queue.
+		if !l.reserveHistogram(ctx) {
+			level.Error(s.logger).Log("msg", "failed to send to loop", "err", ctx.Err())
+			return
+		}
+	}
+	l.pending.Inc()
+	err := mbx.Send(ctx, ts)
 	if err != nil {
+		l.pending.Dec()
+		if isHistogram {
+			l.releaseHistogram()
+		}
 		level.Error(s.logger).Log("msg", "failed to send to loop", "err", err)
 	}
 }
+
+// queueMetadata sends metadata to the metadata loops in turn.
+func (s *manager) queueMetadata(ctx context.Context, ts *types.TimeSeriesBinary) {
+	// OTLP has no requests with only metadata, so it isn't sent.
+	if s.cfg.Protocol == types.ProtocolOTLP {
+		types.PutTimeSeriesIntoPool(ts)
+		return
+	}
+	l := s.metadata[s.nextMetadata]
+	s.nextMetadata = (s.nextMetadata + 1) % len(s.metadata)
+	l.pending.Inc()
+	err := l.seriesMbx.Send(ctx, ts)
+	if err != nil {
+		l.pending.Dec()
+		level.Error(s.logger).Log("msg", "failed to send to metadata loop", "err", err)
+	}
+}
+
+// flush asks every loop to send what it has queued and waits for them to finish. Since the manager handles one
+// message at a time nothing new is queued, and a config update can't replace the loops while flushing.
+func (s *manager) flush(ctx context.Context) error {
+	// The caller may have given up before the flush was received.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Series sent before the flush may still be waiting in the inboxes.
+	for s.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ts, ok := <-s.inbox.ReceiveC():
+			if !ok {
+				return nil
+			}
+			s.pending.Dec()
+			s.queue(ctx, ts)
+		case ts, ok := <-s.metaInbox.ReceiveC():
+			if !ok {
+				return nil
+			}
+			s.pending.Dec()
+			s.queueMetadata(ctx, ts)
+		}
+	}
+	loops := make([]*loop, 0, len(s.loops)+len(s.metadata))
+	loops = append(loops, s.loops...)
+	loops = append(loops, s.metadata...)
+	dones := make([]chan struct{}, 0, len(loops))
+	for _, l := range loops {
+		done := make(chan struct{})
+		if err := l.flushMbx.Send(ctx, flushRequest{ctx: ctx, done: done}); err != nil {
+			return err
+		}
+		dones = append(dones, done)
+	}
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// reportUtilization reports the average of the series waiting in each loop as a multiple of the batch count.
+func (s *manager) reportUtilization() {
+	if len(s.loops) == 0 {
+		return
+	}
+	var total float64
+	var samples, histograms int64
+	for _, l := range s.loops {
+		total += l.utilization()
+		// The counters are read separately, so a histogram being received may be missing from pending already.
+		pendingHistograms := l.pendingHistograms.Load()
+		samples += max(l.pending.Load()-pendingHistograms, 0)
+		histograms += pendingHistograms
+		if s.cfg.PerConnectionMetrics {
+			pending := l.pending.Load()
+			l.statsFunc(types.NetworkStats{
+				PendingSeries: &pending,
+			})
+		}
+	}
+	utilization := total / float64(len(s.loops))
+	s.stats(types.NetworkStats{
+		QueueUtilization:  &utilization,
+		PendingSamples:    &samples,
+		PendingHistograms: &histograms,
+	})
+}
@@ -1,18 +1,23 @@
 package network
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"github.com/grafana/alloy/internal/util"
 	"io"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/golang/snappy"
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/stretchr/testify/require"
@@ -145,6 +150,81 @@ func TestRetry(t *testing.T) {
 	}, 10*time.Second, 1*time.Second)
 }
 
+func TestRetryAfter(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	calls := atomic.Uint32{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Throttle the first request only.
+		if calls.Inc() == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       1 * time.Second,
+		BatchCount:    1,
+		FlushInterval: 1 * time.Second,
+		RetryBackoff:  100 * time.Millisecond,
+		Connections:   1,
+	}
+
+	retryAfter := atomic.Int64{}
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		retryAfter.Add(int64(s.RetryAfter))
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	send(t, wr, ctx)
+	require.Eventually(t, func() bool {
+		return calls.Load() == 2
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, int64(time.Second), retryAfter.Load())
+}
+
+func TestStopDuringRetry(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	calls := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusInternalServerError, func(wr *prompb.WriteRequest) {
+		calls.Inc()
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       1 * time.Second,
+		BatchCount:    1,
+		FlushInterval: 1 * time.Second,
+		RetryBackoff:  1 * time.Minute,
+		Connections:   1,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	send(t, wr, ctx)
+	require.Eventually(t, func() bool {
+		return calls.Load() == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	// The loop is now waiting a minute to retry, stopping should not wait for it.
+	start := time.Now()
+	wr.Stop()
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
 func TestRetryBounded(t *testing.T) {
 	defer goleak.VerifyNone(t)
 
@@ -225,6 +305,35 @@ func TestRecoverable(t *testing.T) {
 	require.True(t, recoverable.Load() == 10*2)
 }
 
+func TestNewInvalidConfig(t *testing.T) {
+	valid := types.ConnectionConfig{
+		URL:           "http://localhost",
+		Timeout:       1 * time.Second,
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+		Connections:   1,
+	}
+	tests := []struct {
+		name string
+		cc   func(cc *types.ConnectionConfig)
+	}{
+		{name: "no connections", cc: func(cc *types.ConnectionConfig) { cc.Connections = 0 }},
+		{name: "no batch count", cc: func(cc *types.ConnectionConfig) { cc.BatchCount = 0 }},
+		{name: "negative histogram batch count", cc: func(cc *types.ConnectionConfig) { cc.HistogramBatchCount = -1 }},
+		{name: "negative max pending histograms", cc: func(cc *types.ConnectionConfig) { cc.MaxPendingHistograms = -1 }},
+		{name: "no flush interval", cc: func(cc *types.ConnectionConfig) { cc.FlushInterval = 0 }},
+		{name: "no timeout", cc: func(cc *types.ConnectionConfig) { cc.Timeout = 0 }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cc := valid
+			tc.cc(&cc)
+			_, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+			require.Error(t, err)
+		})
+	}
+}
+
 func TestNonRecoverable(t *testing.T) {
 	defer goleak.VerifyNone(t)
 
@@ -265,20 +374,1204 @@ func TestNonRecoverable(t *testing.T) {
 	require.True(t, nonRecoverable.Load() == 10)
 }
 
-func send(t *testing.T, wr types.NetworkClient, ctx context.Context) {
-	ts := createSeries(t)
-	// The actual hash is only used for queueing into different buckets.
-	err := wr.SendSeries(ctx, ts)
+func TestStatusCodeOverrides(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	tests := []struct {
+		name       string
+		statusCode int
+		cc         func(cc *types.ConnectionConfig)
+		retried    bool
+	}{
+		{
+			name:       "retryable 400",
+			statusCode: http.StatusBadRequest,
+			cc: func(cc *types.ConnectionConfig) {
+				cc.RetryableStatusCodes = []int{http.StatusBadRequest}
+			},
+			retried: true,
+		},
+		{
+			name:       "non retryable 500",
+			statusCode: http.StatusInternalServerError,
+			cc: func(cc *types.ConnectionConfig) {
+				cc.NonRetryableStatusCodes = []int{http.StatusInternalServerError}
+			},
+			retried: false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := atomic.Uint32{}
+			svr := httptest.NewServer(handler(t, tc.statusCode, func(wr *prompb.WriteRequest) {
+				requests.Add(1)
+			}))
+			defer svr.Close()
+			ctx, cncl := context.WithCancel(context.Background())
+			defer cncl()
+
+			cc := types.ConnectionConfig{
+				URL:              svr.URL,
+				Timeout:          1 * time.Second,
+				BatchCount:       1,
+				FlushInterval:    1 * time.Second,
+				RetryBackoff:     100 * time.Millisecond,
+				MaxRetryAttempts: 3,
+				Connections:      1,
+			}
+			tc.cc(&cc)
+
+			wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+			require.NoError(t, err)
+			wr.Start()
+			defer wr.Stop()
+			send(t, wr, ctx)
+			if tc.retried {
+				require.Eventually(t, func() bool {
+					return requests.Load() == 3
+				}, 5*time.Second, 100*time.Millisecond)
+				return
+			}
+			require.Eventually(t, func() bool {
+				return requests.Load() == 1
+			}, 2*time.Second, 100*time.Millisecond)
+			time.Sleep(1 * time.Second)
+			require.Equal(t, uint32(1), requests.Load())
+		})
+	}
+}
+
+func TestReplicaURLs(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	good := atomic.Uint32{}
+	bad := atomic.Uint32{}
+	goodSvr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		good.Add(uint32(len(wr.Timeseries)))
+	}))
+	defer goodSvr.Close()
+	badSvr := httptest.NewServer(handler(t, http.StatusBadRequest, func(wr *prompb.WriteRequest) {
+		bad.Add(1)
+	}))
+	defer badSvr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:              goodSvr.URL,
+		ReplicaURLs:      []string{badSvr.URL},
+		Timeout:          1 * time.Second,
+		BatchCount:       1,
+		FlushInterval:    1 * time.Second,
+		RetryBackoff:     100 * time.Millisecond,
+		MaxRetryAttempts: 1,
+		Connections:      1,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
 	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 10; i++ {
+		send(t, wr, ctx)
+	}
+	// Requests alternate until the bad replica has failed enough to be marked unhealthy, the first failures are
+	// dropped while the last one is retried on the good replica.
+	require.Eventually(t, func() bool {
+		return good.Load() == 8
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, uint32(unhealthyAfterFailures), bad.Load())
 }
 
-func handler(t *testing.T, code int, callback func(wr *prompb.WriteRequest)) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func TestTTLDropDuringRetry(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	sends := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusInternalServerError, func(wr *prompb.WriteRequest) {
+		sends.Add(1)
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       1 * time.Second,
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+		RetryBackoff:  100 * time.Millisecond,
+		Connections:   1,
+		TTL:           1 * time.Second,
+	}
+
+	dropped := atomic.Uint32{}
+	logger := log.NewNopLogger()
+	wr, err := New(cc, logger, func(s types.NetworkStats) {
+		dropped.Add(uint32(s.TotalTTLDropped()))
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 10; i++ {
+		send(t, wr, ctx)
+	}
+	require.Eventually(t, func() bool {
+		return dropped.Load() == 10
+	}, 5*time.Second, 100*time.Millisecond)
+	// Once dropped there should be no more retries.
+	sent := sends.Load()
+	time.Sleep(1 * time.Second)
+	require.Equal(t, sent, sends.Load())
+}
+
+func TestAdaptiveBatchCount(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		buf, err := io.ReadAll(r.Body)
 		require.NoError(t, err)
-		defer r.Body.Close()
 		decoded, err := snappy.Decode(nil, buf)
 		require.NoError(t, err)
+		wr := &prompb.WriteRequest{}
+		require.NoError(t, wr.Unmarshal(decoded))
+		if len(wr.Timeseries) > 5 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                svr.URL,
+		Timeout:            1 * time.Second,
+		BatchCount:         20,
+		FlushInterval:      1 * time.Second,
+		Connections:        1,
+		AdaptiveBatchCount: true,
+	}
+
+	batchCount := atomic.Int32{}
+	logger := log.NewNopLogger()
+	wr, err := New(cc, logger, func(s types.NetworkStats) {
+		if s.BatchCount != 0 {
+			batchCount.Store(int32(s.BatchCount))
+		}
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 100; i++ {
+		send(t, wr, ctx)
+	}
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() > 0
+	}, 10*time.Second, 100*time.Millisecond)
+	require.True(t, batchCount.Load() <= 5)
+}
+
+func TestAdaptiveBatchCountTotal(t *testing.T) {
+	cc := types.ConnectionConfig{
+		URL:                "http://localhost",
+		Timeout:            1 * time.Second,
+		BatchCount:         10,
+		FlushInterval:      1 * time.Second,
+		Connections:        3,
+		AdaptiveBatchCount: true,
+	}
+	var reported []int
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		if s.BatchCount != 0 {
+			reported = append(reported, s.BatchCount)
+		}
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	m := wr.(*manager)
+	// The sum is published before anything is sent.
+	m.resetBatchCount(m.loops, &m.batchCount, m.stats)
+	require.Equal(t, []int{30}, reported)
+	// A single loop halving its batch count lowers the sum instead of overwriting it.
+	m.loops[1].adaptBatchCount(sendResult{statusCode: http.StatusRequestEntityTooLarge})
+	require.Equal(t, []int{30, 25}, reported)
+}
+
+func TestFlushStagger(t *testing.T) {
+	cc := types.ConnectionConfig{
+		URL:           "http://localhost",
+		Timeout:       1 * time.Second,
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+		FlushStagger:  4 * time.Second,
+		Connections:   4,
+	}
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	loops := wr.(*manager).loops
+	for i := 1; i < len(loops); i++ {
+		// Each loop should be offset by roughly a second from the previous.
+		diff := loops[i].lastSend.Sub(loops[i-1].lastSend)
+		require.InDelta(t, time.Second, diff, float64(100*time.Millisecond))
+	}
+}
+
+func TestHistogramBatchCount(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	maxBatch := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+		if uint32(len(wr.Timeseries)) > maxBatch.Load() {
+			maxBatch.Store(uint32(len(wr.Timeseries)))
+		}
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                 svr.URL,
+		Timeout:             1 * time.Second,
+		BatchCount:          100,
+		HistogramBatchCount: 2,
+		FlushInterval:       1 * time.Second,
+		Connections:         1,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 10; i++ {
+		ts := createSeries(t)
+		ts.FromHistogram(ts.TS, &histogram.Histogram{
+			Count: 1,
+			Sum:   1,
+		})
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 10
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, uint32(2), maxBatch.Load())
+}
+
+func TestMaxPendingHistograms(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// Block the first send so histograms back up in the queue.
+	release := make(chan struct{})
+	recordsFound := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		<-release
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                  svr.URL,
+		Timeout:              10 * time.Second,
+		BatchCount:           100,
+		HistogramBatchCount:  1,
+		MaxPendingHistograms: 2,
+		FlushInterval:        1 * time.Second,
+		Connections:          1,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	m := wr.(*manager)
+	l := m.loops[0]
+	for i := 0; i < 5; i++ {
+		ts := createSeries(t)
+		ts.FromHistogram(ts.TS, &histogram.Histogram{
+			Count: 1,
+			Sum:   1,
+		})
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	// One histogram is being sent and two are pending in the loop, the manager waits with the fourth until the loop
+	// receives one, so the fifth stays in its inbox.
+	require.Eventually(t, func() bool {
+		return l.pendingHistograms.Load() == 2 && m.pending.Load() == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, int64(2), l.pendingHistograms.Load())
+	require.Equal(t, int64(1), m.pending.Load())
+	close(release)
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 5
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, int64(0), l.pendingHistograms.Load())
+}
+
+func TestCompression(t *testing.T) {
+	for _, compression := range []string{types.CompressionSnappy, types.CompressionZstd, types.CompressionGzip, types.CompressionNone} {
+		t.Run(compression, func(t *testing.T) {
+			defer goleak.VerifyNone(t)
+
+			recordsFound := atomic.Uint32{}
+			svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+				recordsFound.Add(uint32(len(wr.Timeseries)))
+			}))
+			defer svr.Close()
+			ctx := context.Background()
+			ctx, cncl := context.WithCancel(ctx)
+			defer cncl()
+
+			cc := types.ConnectionConfig{
+				URL:           svr.URL,
+				Timeout:       1 * time.Second,
+				BatchCount:    10,
+				FlushInterval: 1 * time.Second,
+				Connections:   2,
+				Compression:   compression,
+			}
+
+			wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+			require.NoError(t, err)
+			wr.Start()
+			defer wr.Stop()
+			for i := 0; i < 100; i++ {
+				send(t, wr, ctx)
+			}
+			require.Eventually(t, func() bool {
+				return recordsFound.Load() == 100
+			}, 10*time.Second, 100*time.Millisecond)
+		})
+	}
+}
+
+func TestCompressionMinBytes(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	encodings := make(chan string, 10)
+	records := handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+	})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings <- r.Header.Get("Content-Encoding")
+		records(w, r)
+	}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                 svr.URL,
+		Timeout:             1 * time.Second,
+		BatchCount:          1,
+		FlushInterval:       1 * time.Second,
+		Connections:         1,
+		CompressionMinBytes: 1_000_000,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	send(t, wr, ctx)
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	// The request is smaller than the threshold so it isn't snappy encoded.
+	require.Empty(t, <-encodings)
+
+	cc.CompressionMinBytes = 1
+	require.NoError(t, wr.UpdateConfig(ctx, cc))
+	send(t, wr, ctx)
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 2
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, "snappy", <-encodings)
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	maxSize := atomic.Int64{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if int64(len(buf)) > maxSize.Load() {
+			maxSize.Store(int64(len(buf)))
+		}
+		wr := &prompb.WriteRequest{}
+		require.NoError(t, wr.Unmarshal(buf))
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:             svr.URL,
+		Timeout:         1 * time.Second,
+		BatchCount:      100,
+		FlushInterval:   1 * time.Second,
+		Connections:     1,
+		Compression:     types.CompressionNone,
+		MaxRequestBytes: 500,
+	}
+
+	splits := atomic.Int32{}
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		splits.Add(int32(s.RequestSplits))
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 100; i++ {
+		send(t, wr, ctx)
+	}
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 100
+	}, 5*time.Second, 100*time.Millisecond)
+	require.LessOrEqual(t, maxSize.Load(), int64(500))
+	require.Greater(t, splits.Load(), int32(0))
+}
+
+func TestPerConnectionMetrics(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                  svr.URL,
+		Timeout:              1 * time.Second,
+		BatchCount:           1,
+		FlushInterval:        1 * time.Second,
+		Connections:          2,
+		PerConnectionMetrics: true,
+	}
+
+	var mut sync.Mutex
+	sent := map[string]int{}
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		mut.Lock()
+		defer mut.Unlock()
+		sent[s.Connection] += s.TotalSent()
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 10; i++ {
+		ts := createSeries(t)
+		// One series goes to the first connection for every four that go to the second.
+		if i%5 == 0 {
+			ts.Hash = 0
+		} else {
+			ts.Hash = 1
+		}
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	require.Eventually(t, func() bool {
+		mut.Lock()
+		defer mut.Unlock()
+		return sent["0"] == 2 && sent["1"] == 8
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestQueueUtilization(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// Block the first send so series back up in the queue.
+	release := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       10 * time.Second,
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+		Connections:   2,
+	}
+
+	var utilization atomic.Float64
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		if s.QueueUtilization != nil {
+			utilization.Store(*s.QueueUtilization)
+		}
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	m := wr.(*manager)
+	l := m.loops[0]
+	for i := 0; i < 30; i++ {
+		ts := createSeries(t)
+		// Send everything to the first loop.
+		ts.Hash = 0
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	// The first batch is being sent, the rest is waiting in the queue.
+	require.Eventually(t, func() bool {
+		return l.pending.Load() == 20
+	}, 5*time.Second, 100*time.Millisecond)
+	// Twenty series queued are two batches, with the other loop empty.
+	require.Equal(t, 2.0, l.utilization())
+	require.Eventually(t, func() bool {
+		return utilization.Load() == 1
+	}, 2*utilizationInterval, 100*time.Millisecond)
+	close(release)
+}
+
+func TestMetadataParallelism(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// Only respond once both requests are in flight, which can only happen if they are sent in parallel.
+	inFlight := atomic.Int32{}
+	both := make(chan struct{})
+	recordsFound := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		if inFlight.Inc() == 2 {
+			close(both)
+		}
+		select {
+		case <-both:
+		case <-time.After(5 * time.Second):
+		}
+		recordsFound.Add(uint32(len(wr.Metadata)))
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                 svr.URL,
+		Timeout:             10 * time.Second,
+		BatchCount:          1,
+		FlushInterval:       1 * time.Second,
+		Connections:         1,
+		MetadataParallelism: 2,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 2; i++ {
+		md := createSeries(t)
+		md.Labels = append(md.Labels,
+			labels.Label{Name: types.MetaType, Value: "counter"},
+			labels.Label{Name: types.MetaHelp, Value: "help"},
+			labels.Label{Name: types.MetaUnit, Value: "seconds"},
+		)
+		require.NoError(t, wr.SendMetadata(ctx, md))
+	}
+	start := time.Now()
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 2
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestFlush(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       1 * time.Second,
+		BatchCount:    100,
+		FlushInterval: 10 * time.Second,
+		Connections:   2,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 10; i++ {
+		send(t, wr, ctx)
+	}
+	// Neither the batch count nor the flush interval are reached so only the flush sends the series.
+	flushCtx, flushCncl := context.WithTimeout(ctx, 5*time.Second)
+	defer flushCncl()
+	require.NoError(t, wr.(*manager).flushAndWait(flushCtx))
+	require.Equal(t, uint32(10), recordsFound.Load())
+
+	// The manager keeps running after a flush.
+	for i := 0; i < 10; i++ {
+		send(t, wr, ctx)
+	}
+	require.NoError(t, wr.(*manager).flushAndWait(flushCtx))
+	require.Equal(t, uint32(20), recordsFound.Load())
+}
+
+func TestFlushCancelled(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	release := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       10 * time.Second,
+		BatchCount:    100,
+		FlushInterval: 10 * time.Second,
+		Connections:   1,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	send(t, wr, ctx)
+	flushCtx, flushCncl := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer flushCncl()
+	require.ErrorIs(t, wr.(*manager).flushAndWait(flushCtx), context.DeadlineExceeded)
+	close(release)
+}
+
+func TestBatchTrigger(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+	}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       1 * time.Second,
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+		Connections:   1,
+	}
+
+	var mut sync.Mutex
+	var triggers []string
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		if s.BatchTrigger != "" {
+			mut.Lock()
+			triggers = append(triggers, s.BatchTrigger)
+			mut.Unlock()
+		}
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+
+	// A full batch is sent right away.
+	for i := 0; i < 10; i++ {
+		send(t, wr, ctx)
+	}
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 10
+	}, 5*time.Second, 100*time.Millisecond)
+	// A partial batch waits for the flush interval.
+	for i := 0; i < 5; i++ {
+		send(t, wr, ctx)
+	}
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 15
+	}, 5*time.Second, 100*time.Millisecond)
+	send(t, wr, ctx)
+	require.NoError(t, wr.(*manager).flushAndWait(ctx))
+	require.Equal(t, uint32(16), recordsFound.Load())
+
+	mut.Lock()
+	defer mut.Unlock()
+	require.Equal(t, []string{types.BatchTriggerFull, types.BatchTriggerTimer, types.BatchTriggerFlush}, triggers)
+}
+
+func TestStopWithTimeout(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	received := atomic.Int32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		received.Add(int32(len(wr.Timeseries)))
+	}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:     svr.URL,
+		Timeout: 10 * time.Second,
+		// Nothing is sent until the batch is full or the flush interval passes.
+		BatchCount:    100,
+		FlushInterval: 1 * time.Minute,
+		Connections:   2,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	for i := 0; i < 10; i++ {
+		send(t, wr, ctx)
+	}
+	wr.StopWithTimeout(5 * time.Second)
+	require.Equal(t, int32(10), received.Load())
+}
+
+func TestStopWithTimeoutElapsed(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	release := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	defer close(release)
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       10 * time.Second,
+		BatchCount:    100,
+		FlushInterval: 1 * time.Minute,
+		Connections:   1,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	send(t, wr, ctx)
+	// The endpoint never responds, so stopping must not wait for much longer than the timeout.
+	start := time.Now()
+	wr.StopWithTimeout(200 * time.Millisecond)
+	require.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestHeaders(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	tenant := atomic.String{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant.Store(r.Header.Get("X-Scope-OrgID"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       1 * time.Second,
+		BatchCount:    1,
+		FlushInterval: 1 * time.Second,
+		Connections:   1,
+		Headers:       map[string]string{"X-Scope-OrgID": "tenant-1"},
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	send(t, wr, ctx)
+	require.Eventually(t, func() bool {
+		return tenant.Load() == "tenant-1"
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestDryRun(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		// Nothing is listening here, any request would fail.
+		URL:           "http://127.0.0.1:1",
+		Timeout:       1 * time.Second,
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+		Connections:   2,
+		DryRun:        true,
+	}
+
+	dryRun := atomic.Int32{}
+	sent := atomic.Int32{}
+	failed := atomic.Int32{}
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		dryRun.Add(int32(s.DryRunRequests))
+		sent.Add(int32(s.TotalSent() + s.RequestsSent + s.SeriesBytes))
+		failed.Add(int32(s.TotalFailed() + s.Series.NetworkSamplesFailed))
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 100; i++ {
+		send(t, wr, ctx)
+	}
+	// 100 series in batches of at most 10.
+	require.Eventually(t, func() bool {
+		return dryRun.Load() >= 10
+	}, 5*time.Second, 100*time.Millisecond)
+	// Nothing reached the endpoint, so nothing is counted as sent.
+	require.Zero(t, sent.Load())
+	require.Zero(t, failed.Load())
+}
+
+func TestDropLabels(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		for _, ts := range wr.Timeseries {
+			names := make([]string, 0, len(ts.Labels))
+			for _, lbl := range ts.Labels {
+				names = append(names, lbl.Name)
+			}
+			// The external label must survive even though it is also listed in drop labels.
+			require.Equal(t, []string{"__name__", "job", "cluster"}, names)
+		}
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	ctx, cncl := context.WithCancel(ctx)
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:            svr.URL,
+		Timeout:        1 * time.Second,
+		BatchCount:     10,
+		FlushInterval:  1 * time.Second,
+		Connections:    1,
+		ExternalLabels: map[string]string{"cluster": "test"},
+		DropLabels:     []string{"pod_template_hash", "cluster"},
+	}
+
+	dropped := atomic.Int32{}
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		dropped.Add(int32(s.LabelsDropped))
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 10; i++ {
+		ts := createSeries(t)
+		ts.Labels = append(ts.Labels,
+			labels.Label{Name: "pod_template_hash", Value: randSeq(10)},
+			labels.Label{Name: "job", Value: "test"},
+		)
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 10
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, int32(10), dropped.Load())
+}
+
+func TestMaxConcurrentSends(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	release := make(chan struct{})
+	var current, highest, received atomic.Int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := current.Inc()
+		defer current.Dec()
+		for {
+			h := highest.Load()
+			if n <= h || highest.CompareAndSwap(h, n) {
+				break
+			}
+		}
+		<-release
+		received.Inc()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                svr.URL,
+		Timeout:            10 * time.Second,
+		BatchCount:         1,
+		FlushInterval:      1 * time.Second,
+		Connections:        4,
+		MaxConcurrentSends: 2,
+	}
+
+	var inFlight atomic.Int32
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		inFlight.Add(int32(s.InFlightSends))
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 8; i++ {
+		ts := createSeries(t)
+		ts.Hash = uint64(i)
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	// Every loop has a batch ready, but only two may be sent at once.
+	require.Eventually(t, func() bool {
+		return current.Load() == 2 && inFlight.Load() == 2
+	}, 5*time.Second, 100*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, int32(2), current.Load())
+	close(release)
+	require.Eventually(t, func() bool {
+		return received.Load() == 8 && inFlight.Load() == 0
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, int32(2), highest.Load())
+}
+
+func TestMaxSamplesPerSecond(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recordsFound := atomic.Uint32{}
+	svr := httptest.NewServer(handler(t, http.StatusOK, func(wr *prompb.WriteRequest) {
+		recordsFound.Add(uint32(len(wr.Timeseries)))
+	}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:                 svr.URL,
+		Timeout:             1 * time.Second,
+		BatchCount:          10,
+		FlushInterval:       1 * time.Second,
+		Connections:         2,
+		MaxSamplesPerSecond: 10,
+	}
+
+	var rateLimited atomic.Int64
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {
+		rateLimited.Add(int64(s.RateLimited))
+	}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 40; i++ {
+		ts := createSeries(t)
+		ts.Hash = uint64(i)
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	// Both loops have full batches ready, but the limit is shared so only one batch is sent each second.
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 10
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, uint32(10), recordsFound.Load())
+	require.Eventually(t, func() bool {
+		return recordsFound.Load() == 40
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Greater(t, rateLimited.Load(), int64(0))
+}
+
+func TestStatus(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// Block the first send so series back up in the queue.
+	release := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	defer close(release)
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       10 * time.Second,
+		BatchCount:    10,
+		FlushInterval: 1 * time.Second,
+		Connections:   2,
+		Protocol:      types.ProtocolPrometheus,
+		Compression:   types.CompressionSnappy,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	for i := 0; i < 30; i++ {
+		ts := createSeries(t)
+		ts.Hash = 0
+		require.NoError(t, wr.SendSeries(ctx, ts))
+	}
+	// The first batch is being sent, the rest is waiting in the queue.
+	require.Eventually(t, func() bool {
+		return wr.Status().PendingSeries == 20
+	}, 5*time.Second, 100*time.Millisecond)
+	status := wr.Status()
+	require.Equal(t, 2, status.Connections)
+	require.Equal(t, 1, status.MetadataConnections)
+	require.Zero(t, status.Unrouted)
+	require.Equal(t, types.ProtocolPrometheus, status.Protocol)
+	require.Equal(t, types.CompressionSnappy, status.Compression)
+
+	// Status can be read while the loops are replaced.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = wr.Status()
+		}
+	}()
+	cc.Connections = 3
+	cc.Compression = types.CompressionZstd
+	require.NoError(t, wr.UpdateConfig(ctx, cc))
+	<-done
+	require.Equal(t, 3, wr.Status().Connections)
+	require.Equal(t, types.CompressionZstd, wr.Status().Compression)
+}
+
+func TestStatusLastError(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	code := atomic.Int32{}
+	code.Store(http.StatusBadRequest)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(code.Load()))
+	}))
+	defer svr.Close()
+	ctx, cncl := context.WithCancel(context.Background())
+	defer cncl()
+
+	cc := types.ConnectionConfig{
+		URL:           svr.URL,
+		Timeout:       1 * time.Second,
+		BatchCount:    1,
+		FlushInterval: 1 * time.Second,
+		Connections:   1,
+	}
+
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+	require.NoError(t, wr.Status().LastError)
+
+	// The batch is dropped, so its error is kept.
+	start := time.Now()
+	send(t, wr, ctx)
+	require.Eventually(t, func() bool {
+		return wr.Status().LastError != nil
+	}, 5*time.Second, 100*time.Millisecond)
+	status := wr.Status()
+	require.ErrorContains(t, status.LastError, "400")
+	require.False(t, status.LastErrorTime.Before(start))
+
+	// The next successful send clears it.
+	code.Store(http.StatusOK)
+	send(t, wr, ctx)
+	require.Eventually(t, func() bool {
+		return wr.Status().LastError == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	require.True(t, wr.Status().LastErrorTime.IsZero())
+}
+
+func TestSharedHTTPClient(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	cc := types.ConnectionConfig{
+		URL:                 "http://localhost",
+		Timeout:             1 * time.Second,
+		BatchCount:          10,
+		FlushInterval:       1 * time.Second,
+		Connections:         3,
+		MetadataParallelism: 2,
+	}
+	wr, err := New(cc, log.NewNopLogger(), func(s types.NetworkStats) {}, func(s types.NetworkStats) {})
+	require.NoError(t, err)
+	wr.Start()
+	defer wr.Stop()
+
+	requireShared := func(idle int) {
+		m := wr.(*manager)
+		m.loopsMut.RLock()
+		defer m.loopsMut.RUnlock()
+		// Every loop can keep its connection idle between sends.
+		require.Equal(t, idle, m.client.Transport.(*http.Transport).MaxIdleConnsPerHost)
+		for _, l := range append(m.loops, m.metadata...) {
+			require.Same(t, m.client, l.client)
+		}
+	}
+	requireShared(5)
+	cc.Connections = 4
+	require.NoError(t, wr.UpdateConfig(context.Background(), cc))
+	requireShared(6)
+}
+
+func send(t *testing.T, wr types.NetworkClient, ctx context.Context) {
+	ts := createSeries(t)
+	// The actual hash is only used for queueing into different buckets.
+	err := wr.SendSeries(ctx, ts)
+	require.NoError(t, err)
+}
+
+func handler(t *testing.T, code int, callback func(wr *prompb.WriteRequest)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		defer r.Body.Close()
+		var decoded []byte
+		switch r.Header.Get("Content-Encoding") {
+		case "snappy":
+			decoded, err = snappy.Decode(nil, buf)
+		case "zstd":
+			var dec *zstd.Decoder
+			dec, err = zstd.NewReader(nil)
+			require.NoError(t, err)
+			defer dec.Close()
+			decoded, err = dec.DecodeAll(buf, nil)
+		case "gzip":
+			var gr *gzip.Reader
+			gr, err = gzip.NewReader(bytes.NewReader(buf))
+			require.NoError(t, err)
+			decoded, err = io.ReadAll(gr)
+		default:
+			decoded = buf
+		}
+		require.NoError(t, err)
 
 		wr := &prompb.WriteRequest{}
 		err = wr.Unmarshal(decoded)
@@ -290,7 +1583,7 @@ func handler(t *testing.T, code int, callback func(wr *prompb.WriteRequest)) htt
 
 func createSeries(_ *testing.T) *types.TimeSeriesBinary {
 	ts := &types.TimeSeriesBinary{
-		TS:    time.Now().Unix(),
+		TS:    time.Now().UnixMilli(),
 		Value: 1,
 		Labels: []labels.Label{
 			{
@@ -0,0 +1,191 @@
+package network
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+type otlpResource struct {
+	job      string
+	instance string
+}
+
+type otlpMetric struct {
+	resource  otlpResource
+	name      string
+	histogram bool
+}
+
+// createOTLPRequest converts the series into an OTLP ExportMetricsServiceRequest following the Prometheus
+// compatibility spec. The job and instance labels become the service resource attributes and the other labels become
+// data point attributes. The type of a sample isn't known so samples are sent as gauges, native histograms are sent
+// as exponential histograms.
+func createOTLPRequest(series []*types.TimeSeriesBinary, externalLabels map[string]string) ([]byte, uncompressedBytes, error) {
+	md := pmetric.NewMetrics()
+	resources := make(map[otlpResource]pmetric.MetricSlice)
+	metrics := make(map[otlpMetric]pmetric.Metric)
+	histogramCount := 0
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	for _, ts := range series {
+		lb.Reset(ts.Labels)
+		for k, v := range externalLabels {
+			lb.Set(k, v)
+		}
+		lbls := lb.Labels()
+
+		resource := otlpResource{
+			job:      lbls.Get("job"),
+			instance: lbls.Get("instance"),
+		}
+		slice, found := resources[resource]
+		if !found {
+			rm := md.ResourceMetrics().AppendEmpty()
+			setServiceAttributes(rm.Resource().Attributes(), resource)
+			slice = rm.ScopeMetrics().AppendEmpty().Metrics()
+			resources[resource] = slice
+		}
+
+		key := otlpMetric{
+			resource:  resource,
+			name:      lbls.Get(labels.MetricName),
+			histogram: ts.IsHistogram(),
+		}
+		m, found := metrics[key]
+		if !found {
+			m = slice.AppendEmpty()
+			m.SetName(key.name)
+			if key.histogram {
+				m.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+			} else {
+				m.SetEmptyGauge()
+			}
+			metrics[key] = m
+		}
+
+		var attrs pcommon.Map
+		if key.histogram {
+			histogramCount++
+			dp := m.ExponentialHistogram().DataPoints().AppendEmpty()
+			setExponentialHistogram(dp, ts)
+			attrs = dp.Attributes()
+		} else {
+			dp := m.Gauge().DataPoints().AppendEmpty()
+			dp.SetTimestamp(otlpTimestamp(ts.TS))
+			if value.IsStaleNaN(ts.Value) {
+				dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+			} else {
+				dp.SetDoubleValue(ts.Value)
+			}
+			attrs = dp.Attributes()
+		}
+		lbls.Range(func(l labels.Label) {
+			switch l.Name {
+			case labels.MetricName, "job", "instance":
+			default:
+				attrs.PutStr(l.Name, l.Value)
+			}
+		})
+	}
+
+	data, err := pmetricotlp.NewExportRequestFromMetrics(md).MarshalProto()
+	if err != nil {
+		return nil, uncompressedBytes{}, err
+	}
+	// Unlike remote write the size of each series isn't known, so the split between types is by count.
+	var sizes uncompressedBytes
+	if len(series) > 0 {
+		sizes.histograms = len(data) * histogramCount / len(series)
+	}
+	sizes.samples = len(data) - sizes.histograms
+	return data, sizes, nil
+}
+
+// setServiceAttributes sets the resource attributes from job and instance, a job of the form namespace/name is split.
+func setServiceAttributes(attrs pcommon.Map, resource otlpResource) {
+	if resource.job != "" {
+		if namespace, name, found := strings.Cut(resource.job, "/"); found {
+			attrs.PutStr("service.namespace", namespace)
+			attrs.PutStr("service.name", name)
+		} else {
+			attrs.PutStr("service.name", resource.job)
+		}
+	}
+	if resource.instance != "" {
+		attrs.PutStr("service.instance.id", resource.instance)
+	}
+}
+
+func setExponentialHistogram(dp pmetric.ExponentialHistogramDataPoint, ts *types.TimeSeriesBinary) {
+	dp.SetTimestamp(otlpTimestamp(ts.TS))
+	if h := ts.Histograms.Histogram; h != nil {
+		dp.SetScale(h.Schema)
+		dp.SetSum(h.Sum)
+		dp.SetCount(h.Count.IntValue)
+		dp.SetZeroThreshold(h.ZeroThreshold)
+		dp.SetZeroCount(h.ZeroCount.IntValue)
+		setBuckets(dp.Positive(), h.PositiveSpans, deltasToCounts(h.PositiveBuckets))
+		setBuckets(dp.Negative(), h.NegativeSpans, deltasToCounts(h.NegativeBuckets))
+		return
+	}
+	h := ts.Histograms.FloatHistogram
+	dp.SetScale(h.Schema)
+	dp.SetSum(h.Sum)
+	dp.SetCount(uint64(math.Round(h.Count.FloatValue)))
+	dp.SetZeroThreshold(h.ZeroThreshold)
+	dp.SetZeroCount(uint64(math.Round(h.ZeroCount.FloatValue)))
+	setBuckets(dp.Positive(), h.PositiveSpans, roundCounts(h.PositiveCounts))
+	setBuckets(dp.Negative(), h.NegativeSpans, roundCounts(h.NegativeCounts))
+}
+
+// setBuckets fills the OTLP buckets from Prometheus spans. A Prometheus bucket index is its upper bound while an OTLP
+// index is its lower bound, so the offset is one less. Gaps between spans are filled with empty buckets.
+func setBuckets(b pmetric.ExponentialHistogramDataPointBuckets, spans []types.BucketSpan, counts []uint64) {
+	if len(spans) == 0 {
+		return
+	}
+	b.SetOffset(spans[0].Offset - 1)
+	bucketCounts := b.BucketCounts()
+	next := 0
+	for i, span := range spans {
+		if i > 0 {
+			for j := int32(0); j < span.Offset; j++ {
+				bucketCounts.Append(0)
+			}
+		}
+		for j := uint32(0); j < span.Length && next < len(counts); j++ {
+			bucketCounts.Append(counts[next])
+			next++
+		}
+	}
+}
+
+// deltasToCounts converts the delta encoded buckets of an integer histogram to absolute counts.
+func deltasToCounts(deltas []int64) []uint64 {
+	counts := make([]uint64, len(deltas))
+	var current int64
+	for i, d := range deltas {
+		current += d
+		counts[i] = uint64(current)
+	}
+	return counts
+}
+
+func roundCounts(buckets []float64) []uint64 {
+	counts := make([]uint64, len(buckets))
+	for i, c := range buckets {
+		counts[i] = uint64(math.Round(c))
+	}
+	return counts
+}
+
+func otlpTimestamp(ms int64) pcommon.Timestamp {
+	return pcommon.Timestamp(ms * int64(time.Millisecond))
+}
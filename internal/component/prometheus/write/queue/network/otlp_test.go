@@ -0,0 +1,70 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+func TestCreateOTLPRequest(t *testing.T) {
+	sample := &types.TimeSeriesBinary{
+		TS:     1_000,
+		Value:  5,
+		Labels: labels.FromStrings("__name__", "requests", "job", "ns/app", "instance", "host:80", "path", "/"),
+	}
+	hist := &types.TimeSeriesBinary{
+		TS:     2_000,
+		Labels: labels.FromStrings("__name__", "latency", "job", "ns/app", "instance", "host:80"),
+	}
+	hist.FromHistogram(2_000, &histogram.Histogram{
+		Schema:        0,
+		Count:         6,
+		Sum:           10,
+		ZeroThreshold: 0.001,
+		ZeroCount:     1,
+		// Buckets 1 and 2, then a gap of one and bucket 4.
+		PositiveSpans:   []histogram.Span{{Offset: 1, Length: 2}, {Offset: 1, Length: 1}},
+		PositiveBuckets: []int64{1, 1, -1},
+	})
+
+	data, sizes, err := createOTLPRequest([]*types.TimeSeriesBinary{sample, hist}, map[string]string{"cluster": "a"})
+	require.NoError(t, err)
+	require.Equal(t, len(data), sizes.samples+sizes.histograms)
+
+	req := pmetricotlp.NewExportRequest()
+	require.NoError(t, req.UnmarshalProto(data))
+	md := req.Metrics()
+	// Both series share a job and instance, so they share a resource.
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	rm := md.ResourceMetrics().At(0)
+	require.Equal(t, map[string]any{
+		"service.namespace":   "ns",
+		"service.name":        "app",
+		"service.instance.id": "host:80",
+	}, rm.Resource().Attributes().AsRaw())
+
+	metrics := rm.ScopeMetrics().At(0).Metrics()
+	require.Equal(t, 2, metrics.Len())
+
+	gauge := metrics.At(0)
+	require.Equal(t, "requests", gauge.Name())
+	require.Equal(t, pmetric.MetricTypeGauge, gauge.Type())
+	dp := gauge.Gauge().DataPoints().At(0)
+	require.Equal(t, 5.0, dp.DoubleValue())
+	require.Equal(t, int64(1_000_000_000), int64(dp.Timestamp()))
+	require.Equal(t, map[string]any{"path": "/", "cluster": "a"}, dp.Attributes().AsRaw())
+
+	exp := metrics.At(1)
+	require.Equal(t, "latency", exp.Name())
+	require.Equal(t, pmetric.MetricTypeExponentialHistogram, exp.Type())
+	hdp := exp.ExponentialHistogram().DataPoints().At(0)
+	require.Equal(t, uint64(6), hdp.Count())
+	require.Equal(t, uint64(1), hdp.ZeroCount())
+	require.Equal(t, int32(0), hdp.Positive().Offset())
+	require.Equal(t, []uint64{1, 2, 0, 1}, hdp.Positive().BucketCounts().AsRaw())
+}
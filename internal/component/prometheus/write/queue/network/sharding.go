@@ -0,0 +1,65 @@
+package network
+
+import (
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+// roundRobinCacheSize is how many series assignments the round robin strategy remembers when
+// RoundRobinCacheSize isn't set.
+const roundRobinCacheSize = 100_000
+
+// shardingStrategy picks the loop a series is queued to. All samples of a series must be queued to the same loop
+// so they are sent in order.
+type shardingStrategy interface {
+	shard(hash uint64) uint64
+}
+
+func newShardingStrategy(cc types.ConnectionConfig) shardingStrategy {
+	if cc.Sharding == types.ShardingRoundRobin {
+		size := cc.RoundRobinCacheSize
+		if size <= 0 {
+			size = roundRobinCacheSize
+		}
+		return newRoundRobinSharding(uint64(cc.Connections), size)
+	}
+	return &hashSharding{shards: uint64(cc.Connections)}
+}
+
+// hashSharding queues a series based on its label hash.
+type hashSharding struct {
+	shards uint64
+}
+
+func (h *hashSharding) shard(hash uint64) uint64 {
+	return hash % h.shards
+}
+
+// roundRobinSharding assigns each new series to the next loop in turn, which evens out the series per loop when
+// a few busy series share the same hash modulo. Assignments are kept in an LRU, a series that is evicted is assigned
+// to the next loop in turn when it is seen again. Its samples are only sent in order if the earlier ones have been
+// sent by then, so the cache must hold more series than the endpoint receives within a flush interval.
+type roundRobinSharding struct {
+	shards   uint64
+	next     uint64
+	assigned *simplelru.LRU[uint64, uint64]
+}
+
+func newRoundRobinSharding(shards uint64, size int) *roundRobinSharding {
+	// The only error is for a non positive size.
+	assigned, _ := simplelru.NewLRU[uint64, uint64](size, nil)
+	return &roundRobinSharding{
+		shards:   shards,
+		assigned: assigned,
+	}
+}
+
+func (r *roundRobinSharding) shard(hash uint64) uint64 {
+	if s, found := r.assigned.Get(hash); found {
+		return s
+	}
+	s := r.next
+	r.next = (r.next + 1) % r.shards
+	r.assigned.Add(hash, s)
+	return s
+}
@@ -0,0 +1,43 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundRobinSharding(t *testing.T) {
+	s := newShardingStrategy(types.ConnectionConfig{
+		Connections: 4,
+		Sharding:    types.ShardingRoundRobin,
+	})
+	// These all share the same modulo so would all be sent to the same loop with hash sharding.
+	hashes := []uint64{0, 4, 8, 12}
+	for i, h := range hashes {
+		require.Equal(t, uint64(i), s.shard(h))
+	}
+	// A series must always be sent to the same loop.
+	for i, h := range hashes {
+		require.Equal(t, uint64(i), s.shard(h))
+	}
+}
+
+func TestRoundRobinShardingEviction(t *testing.T) {
+	s := newShardingStrategy(types.ConnectionConfig{
+		Connections:         2,
+		Sharding:            types.ShardingRoundRobin,
+		RoundRobinCacheSize: 2,
+	})
+	require.Equal(t, uint64(0), s.shard(1))
+	require.Equal(t, uint64(1), s.shard(2))
+	// Seeing a series again keeps it in the cache.
+	require.Equal(t, uint64(0), s.shard(1))
+	// The third series evicts the least recently seen one.
+	require.Equal(t, uint64(0), s.shard(3))
+	require.Equal(t, uint64(0), s.shard(1))
+	require.Equal(t, uint64(0), s.shard(3))
+	// An evicted series is assigned the next loop in turn, which can differ from the one its earlier samples were
+	// queued to. This is why the cache has to be larger than the number of active series.
+	require.Equal(t, uint64(1), s.shard(2))
+}
@@ -8,11 +8,23 @@ import (
 
 // recordStats determines what values to send to the stats function. This allows for any
 // number of metrics/signals libraries to be used. Prometheus, OTel, and any other.
-func recordStats(series []*types.TimeSeriesBinary, isMeta bool, stats func(s types.NetworkStats), r sendResult, bytesSent int) {
+func recordStats(series []*types.TimeSeriesBinary, isMeta bool, stats func(s types.NetworkStats), r sendResult, bytesSent int, uncompressed uncompressedBytes) {
 	seriesCount := getSeriesCount(series)
 	histogramCount := getHistogramCount(series)
 	metadataCount := getMetadataCount(series)
 	switch {
+	case r.circuitOpen:
+		// The batch waits for the circuit breaker without being sent, so it isn't counted as retried.
+	case r.dryRun:
+		// Nothing reached the endpoint, so the series aren't counted as sent and the timestamps stay unchanged.
+		stats(types.NetworkStats{
+			DryRunRequests: 1,
+		})
+	case r.buildError:
+		// The endpoint never saw these series, so they are not counted as failed.
+		stats(types.NetworkStats{
+			BuildFailures: 1,
+		})
 	case r.networkError:
 		stats(types.NetworkStats{
 			Series: types.CategoryStats{
@@ -35,27 +47,34 @@ func recordStats(series []*types.TimeSeriesBinary, isMeta bool, stats func(s typ
 		}
 		var sampleBytesSent int
 		var metaBytesSent int
+		var sent []*types.TimeSeriesBinary
 		// Each loop is explicitly a normal signal or metadata sender.
 		if isMeta {
 			metaBytesSent = bytesSent
 		} else {
 			sampleBytesSent = bytesSent
+			sent = series
 		}
 		stats(types.NetworkStats{
 			Series: types.CategoryStats{
-				SeriesSent: seriesCount,
+				SeriesSent:        seriesCount,
+				UncompressedBytes: uncompressed.samples,
 			},
 			Histogram: types.CategoryStats{
-				SeriesSent: histogramCount,
+				SeriesSent:        histogramCount,
+				UncompressedBytes: uncompressed.histograms,
 			},
 			Metadata: types.CategoryStats{
-				SeriesSent: metadataCount,
+				SeriesSent:        metadataCount,
+				UncompressedBytes: uncompressed.metadata,
 			},
 			MetadataBytes:   metaBytesSent,
 			SeriesBytes:     sampleBytesSent,
 			NewestTimestamp: newestTS,
+			RequestsSent:    1,
+			Sent:            sent,
 		})
-	case r.statusCode == http.StatusTooManyRequests:
+	case r.recoverableError && r.statusCode == http.StatusTooManyRequests:
 		stats(types.NetworkStats{
 			Series: types.CategoryStats{
 				RetriedSamples:    seriesCount,
@@ -70,7 +89,7 @@ func recordStats(series []*types.TimeSeriesBinary, isMeta bool, stats func(s typ
 				RetriedSamples429: metadataCount,
 			},
 		})
-	case r.statusCode/100 == 5:
+	case r.recoverableError && r.statusCode/100 == 5:
 		stats(types.NetworkStats{
 			Series: types.CategoryStats{
 				RetriedSamples5XX: seriesCount,
@@ -82,6 +101,19 @@ func recordStats(series []*types.TimeSeriesBinary, isMeta bool, stats func(s typ
 				RetriedSamples: metadataCount,
 			},
 		})
+	case r.recoverableError:
+		// Any other status code configured to be retried.
+		stats(types.NetworkStats{
+			Series: types.CategoryStats{
+				RetriedSamples: seriesCount,
+			},
+			Histogram: types.CategoryStats{
+				RetriedSamples: histogramCount,
+			},
+			Metadata: types.CategoryStats{
+				RetriedSamples: metadataCount,
+			},
+		})
 	case r.statusCode != 200:
 		stats(types.NetworkStats{
 			Series: types.CategoryStats{
@@ -98,6 +130,21 @@ func recordStats(series []*types.TimeSeriesBinary, isMeta bool, stats func(s typ
 
 }
 
+// recordTTLDropped records series that were dropped because they exceeded the TTL while being retried.
+func recordTTLDropped(series []*types.TimeSeriesBinary, stats func(s types.NetworkStats)) {
+	stats(types.NetworkStats{
+		Series: types.CategoryStats{
+			TTLDroppedSamples: getSeriesCount(series),
+		},
+		Histogram: types.CategoryStats{
+			TTLDroppedSamples: getHistogramCount(series),
+		},
+		Metadata: types.CategoryStats{
+			TTLDroppedSamples: getMetadataCount(series),
+		},
+	})
+}
+
 func getSeriesCount(tss []*types.TimeSeriesBinary) int {
 	cnt := 0
 	for _, ts := range tss {
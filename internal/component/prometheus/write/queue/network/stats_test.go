@@ -0,0 +1,59 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordStatsBuildError(t *testing.T) {
+	series := []*types.TimeSeriesBinary{createSeries(t), createSeries(t)}
+	var reported []types.NetworkStats
+	recordStats(series, false, func(s types.NetworkStats) {
+		reported = append(reported, s)
+	}, sendResult{buildError: true}, 0, uncompressedBytes{})
+
+	require.Len(t, reported, 1)
+	require.Equal(t, 1, reported[0].BuildFailures)
+	// Nothing was sent, so nothing failed at the endpoint.
+	require.Zero(t, reported[0].TotalFailed())
+}
+
+func TestRecordStatsCircuitOpen(t *testing.T) {
+	series := []*types.TimeSeriesBinary{createSeries(t), createSeries(t)}
+	var reported []types.NetworkStats
+	recordStats(series, false, func(s types.NetworkStats) {
+		reported = append(reported, s)
+	}, sendResult{circuitOpen: true, recoverableError: true, err: errCircuitOpen}, 0, uncompressedBytes{})
+
+	// The request was never sent, so nothing is retried yet.
+	require.Empty(t, reported)
+}
+
+func TestRecordStatsDryRun(t *testing.T) {
+	series := []*types.TimeSeriesBinary{createSeries(t), createSeries(t)}
+	var reported []types.NetworkStats
+	recordStats(series, false, func(s types.NetworkStats) {
+		reported = append(reported, s)
+	}, sendResult{successful: true, dryRun: true}, 10, uncompressedBytes{})
+
+	require.Len(t, reported, 1)
+	require.Equal(t, 1, reported[0].DryRunRequests)
+	require.Zero(t, reported[0].RequestsSent)
+	require.Zero(t, reported[0].TotalSent())
+	require.Empty(t, reported[0].Sent)
+}
+
+func TestRecordStatsRequestsSent(t *testing.T) {
+	series := []*types.TimeSeriesBinary{createSeries(t), createSeries(t)}
+	var requests int
+	record := func(s types.NetworkStats) {
+		requests += s.RequestsSent
+	}
+	recordStats(series, false, record, sendResult{successful: true}, 10, uncompressedBytes{})
+	recordStats(series, false, record, sendResult{recoverableError: true, statusCode: 500}, 10, uncompressedBytes{})
+	recordStats(series, true, record, sendResult{successful: true}, 10, uncompressedBytes{})
+	// Only successful requests are counted, whatever they carry.
+	require.Equal(t, 2, requests)
+}
@@ -0,0 +1,47 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+)
+
+// errorThrottle limits how often the same send error is logged, so an endpoint that is down doesn't produce an
+// error for every batch of every loop. It is shared by the loops of a manager.
+type errorThrottle struct {
+	mut      sync.Mutex
+	interval time.Duration
+	// logged is when each distinct error was last logged.
+	logged map[string]time.Time
+}
+
+// newErrorThrottle returns nil if every error is logged.
+func newErrorThrottle(cc types.ConnectionConfig) *errorThrottle {
+	if cc.LogThrottleInterval <= 0 {
+		return nil
+	}
+	return &errorThrottle{
+		interval: cc.LogThrottleInterval,
+		logged:   make(map[string]time.Time),
+	}
+}
+
+// allow returns true if the error hasn't been logged within the interval.
+func (t *errorThrottle) allow(err string) bool {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	now := time.Now()
+	if last, found := t.logged[err]; found && now.Sub(last) < t.interval {
+		return false
+	}
+	// Forget errors that can be logged again so errors that no longer happen don't accumulate.
+	for e, last := range t.logged {
+		if now.Sub(last) >= t.interval {
+			delete(t.logged, e)
+		}
+	}
+	t.logged[err] = now
+	return true
+}
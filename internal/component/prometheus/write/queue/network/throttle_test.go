@@ -0,0 +1,27 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorThrottle(t *testing.T) {
+	require.Nil(t, newErrorThrottle(types.ConnectionConfig{}))
+
+	throttle := newErrorThrottle(types.ConnectionConfig{
+		LogThrottleInterval: 100 * time.Millisecond,
+	})
+	require.True(t, throttle.allow("connection refused"))
+	require.False(t, throttle.allow("connection refused"))
+	// Distinct errors are throttled separately.
+	require.True(t, throttle.allow("server returned HTTP status 500"))
+	require.False(t, throttle.allow("server returned HTTP status 500"))
+
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, throttle.allow("connection refused"))
+	// The other error could be logged again, so it is forgotten.
+	require.Len(t, throttle.logged, 1)
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+)
+
+// connectionTrace reports how long it takes to open a connection and to complete its TLS handshake, which separates
+// the cost of setting up a connection from the time the endpoint takes to respond. A request that reuses an idle
+// connection reports neither.
+type connectionTrace struct {
+	mut sync.Mutex
+	// connecting is when each address started connecting, several addresses can be tried at once.
+	connecting map[string]time.Time
+	tlsStart   time.Time
+	stats      func(types.NetworkStats)
+}
+
+func withConnectionTrace(ctx context.Context, stats func(types.NetworkStats)) context.Context {
+	t := &connectionTrace{
+		connecting: make(map[string]time.Time),
+		stats:      stats,
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		ConnectStart:      t.connectStart,
+		ConnectDone:       t.connectDone,
+		TLSHandshakeStart: t.tlsHandshakeStart,
+		TLSHandshakeDone:  t.tlsHandshakeDone,
+	})
+}
+
+func (t *connectionTrace) connectStart(_, addr string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.connecting[addr] = time.Now()
+}
+
+func (t *connectionTrace) connectDone(_, addr string, err error) {
+	t.mut.Lock()
+	start, found := t.connecting[addr]
+	t.mut.Unlock()
+	if err != nil || !found {
+		return
+	}
+	t.stats(types.NetworkStats{
+		ConnectDuration: time.Since(start),
+	})
+}
+
+func (t *connectionTrace) tlsHandshakeStart() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.tlsStart = time.Now()
+}
+
+func (t *connectionTrace) tlsHandshakeDone(_ tls.ConnectionState, err error) {
+	t.mut.Lock()
+	start := t.tlsStart
+	t.mut.Unlock()
+	if err != nil || start.IsZero() {
+		return
+	}
+	t.stats(types.NetworkStats{
+		TLSHandshakeDuration: time.Since(start),
+	})
+}
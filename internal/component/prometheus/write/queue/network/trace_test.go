@@ -0,0 +1,54 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionTrace(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+	client := svr.Client()
+	defer client.CloseIdleConnections()
+
+	var mut sync.Mutex
+	var connects, handshakes int
+	stats := func(s types.NetworkStats) {
+		mut.Lock()
+		defer mut.Unlock()
+		if s.ConnectDuration > 0 {
+			connects++
+		}
+		if s.TLSHandshakeDuration > 0 {
+			handshakes++
+		}
+	}
+	send := func() {
+		req, err := http.NewRequestWithContext(withConnectionTrace(context.Background(), stats), http.MethodPost, svr.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	send()
+	mut.Lock()
+	require.Equal(t, 1, connects)
+	require.Equal(t, 1, handshakes)
+	mut.Unlock()
+
+	// The idle connection is reused, so there is nothing to report.
+	send()
+	mut.Lock()
+	require.Equal(t, 1, connects)
+	require.Equal(t, 1, handshakes)
+	mut.Unlock()
+}
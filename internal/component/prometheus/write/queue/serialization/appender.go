@@ -15,33 +15,49 @@ import (
 )
 
 type appender struct {
-	ctx    context.Context
-	ttl    time.Duration
-	s      types.Serializer
+	ctx      context.Context
+	ttl      time.Duration
+	s        types.Serializer
+	accepted types.AcceptedTypes
+	// sendCT appends a zero sample at the created timestamp, otherwise created timestamps are dropped.
+	sendCT bool
+	stats  func(types.AppenderStats)
 	logger log.Logger
 }
 
+// AppendCTZeroSample appends a zero sample at the created timestamp so that the endpoint sees where a counter was
+// reset, it is handled like any other sample. Like Prometheus, a created timestamp that isn't older than the sample is ignored.
 func (a *appender) AppendCTZeroSample(ref storage.SeriesRef, l labels.Labels, t, ct int64) (storage.SeriesRef, error) {
-	// TODO @mattdurham figure out what to do here later. This mirrors what we do elsewhere.
-	return ref, nil
+	if !a.sendCT || ct >= t {
+		return ref, nil
+	}
+	return a.Append(ref, l, ct, 0)
 }
 
 // NewAppender returns an Appender that writes to a given serializer. NOTE the returned Appender writes
-// data immediately, discards data older than `ttl` and does not honor commit or rollback.
-func NewAppender(ctx context.Context, ttl time.Duration, s types.Serializer, logger log.Logger) storage.Appender {
+// data immediately, discards data older than `ttl` and does not honor commit or rollback. Data of a type not in
+// `accepted` is silently discarded, as is a sample or histogram without a metric name. Created timestamps are only
+// sent as zero samples if `sendCT` is set.
+func NewAppender(ctx context.Context, ttl time.Duration, s types.Serializer, accepted types.AcceptedTypes, sendCT bool, stats func(types.AppenderStats), logger log.Logger) storage.Appender {
 	app := &appender{
-		ttl:    ttl,
-		s:      s,
-		logger: logger,
-		ctx:    ctx,
+		ttl:      ttl,
+		s:        s,
+		accepted: accepted,
+		sendCT:   sendCT,
+		stats:    stats,
+		logger:   logger,
+		ctx:      ctx,
 	}
 	return app
 }
 
 // Append metric
 func (a *appender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if !a.accepted.Accepts(types.DataTypeSample) || !a.hasName(l) {
+		return ref, nil
+	}
 	// Check to see if the TTL has expired for this record.
-	endTime := time.Now().Unix() - int64(a.ttl.Seconds())
+	endTime := time.Now().UnixMilli() - a.ttl.Milliseconds()
 	if t < endTime {
 		return ref, nil
 	}
@@ -54,6 +70,18 @@ func (a *appender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v flo
 	return ref, err
 }
 
+// hasName counts a series without a metric name as invalid, an endpoint would reject the whole batch it is sent in.
+// Exemplars are not checked since their labels never include the metric name.
+func (a *appender) hasName(l labels.Labels) bool {
+	if l.Get(labels.MetricName) != "" {
+		return true
+	}
+	a.stats(types.AppenderStats{
+		InvalidDropped: 1,
+	})
+	return false
+}
+
 // Commit is a no op since we always write.
 func (a *appender) Commit() (_ error) {
 	return nil
@@ -66,7 +94,10 @@ func (a *appender) Rollback() error {
 
 // AppendExemplar appends exemplar to cache. The passed in labels is unused, instead use the labels on the exemplar.
 func (a *appender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, e exemplar.Exemplar) (_ storage.SeriesRef, _ error) {
-	endTime := time.Now().Unix() - int64(a.ttl.Seconds())
+	if !a.accepted.Accepts(types.DataTypeExemplar) {
+		return ref, nil
+	}
+	endTime := time.Now().UnixMilli() - a.ttl.Milliseconds()
 	if e.HasTs && e.Ts < endTime {
 		return ref, nil
 	}
@@ -81,7 +112,13 @@ func (a *appender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, e exem
 
 // AppendHistogram appends histogram
 func (a *appender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (_ storage.SeriesRef, _ error) {
-	endTime := time.Now().Unix() - int64(a.ttl.Seconds())
+	if h != nil && !a.accepted.Accepts(types.DataTypeHistogram) || h == nil && !a.accepted.Accepts(types.DataTypeFloatHistogram) {
+		return ref, nil
+	}
+	if !a.hasName(l) {
+		return ref, nil
+	}
+	endTime := time.Now().UnixMilli() - a.ttl.Milliseconds()
 	if t < endTime {
 		return ref, nil
 	}
@@ -4,6 +4,8 @@ import (
 	"context"
 	log2 "github.com/go-kit/log"
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -14,18 +16,86 @@ func TestAppenderTTL(t *testing.T) {
 	fake := &counterSerializer{}
 	l := log2.NewNopLogger()
 
-	app := NewAppender(context.Background(), 1*time.Minute, fake, l)
-	_, err := app.Append(0, labels.FromStrings("one", "two"), time.Now().Unix(), 0)
+	app := NewAppender(context.Background(), 1*time.Minute, fake, nil, false, func(types.AppenderStats) {}, l)
+	_, err := app.Append(0, labels.FromStrings("__name__", "one", "two", "three"), time.Now().UnixMilli(), 0)
 	require.NoError(t, err)
 
 	for i := 0; i < 10; i++ {
-		_, err = app.Append(0, labels.FromStrings("one", "two"), time.Now().Add(-5*time.Minute).Unix(), 0)
+		_, err = app.Append(0, labels.FromStrings("__name__", "one", "two", "three"), time.Now().Add(-5*time.Minute).UnixMilli(), 0)
 		require.NoError(t, err)
 	}
 	// Only one record should make it through.
 	require.True(t, fake.received == 1)
 }
 
+func TestAppenderAcceptedTypes(t *testing.T) {
+	fake := &counterSerializer{}
+	l := log2.NewNopLogger()
+
+	app := NewAppender(context.Background(), 1*time.Minute, fake, types.NewAcceptedTypes([]string{types.DataTypeExemplar}), false, func(types.AppenderStats) {}, l)
+	_, err := app.Append(0, labels.FromStrings("__name__", "one", "two", "three"), time.Now().UnixMilli(), 0)
+	require.NoError(t, err)
+	_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "one", "two", "three"), time.Now().UnixMilli(), &histogram.Histogram{}, nil)
+	require.NoError(t, err)
+	_, err = app.AppendExemplar(0, labels.EmptyLabels(), exemplar.Exemplar{
+		Labels: labels.FromStrings("trace_id", "1"),
+		Ts:     time.Now().UnixMilli(),
+		HasTs:  true,
+	})
+	require.NoError(t, err)
+	// Only the exemplar is accepted, the rest is dropped without an error.
+	require.True(t, fake.received == 1)
+}
+
+func TestAppenderDropsNameless(t *testing.T) {
+	fake := &counterSerializer{}
+	l := log2.NewNopLogger()
+
+	var dropped int
+	stats := func(s types.AppenderStats) {
+		dropped += s.InvalidDropped
+	}
+	app := NewAppender(context.Background(), 1*time.Minute, fake, nil, false, stats, l)
+	_, err := app.Append(0, labels.FromStrings("__name__", "one"), time.Now().UnixMilli(), 0)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("one", "two"), time.Now().UnixMilli(), 0)
+	require.NoError(t, err)
+	_, err = app.AppendHistogram(0, labels.EmptyLabels(), time.Now().UnixMilli(), &histogram.Histogram{}, nil)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "two"), time.Now().UnixMilli(), 0)
+	require.NoError(t, err)
+	// Exemplars never carry the metric name so they are not dropped.
+	_, err = app.AppendExemplar(0, labels.EmptyLabels(), exemplar.Exemplar{
+		Labels: labels.FromStrings("trace_id", "1"),
+		Ts:     time.Now().UnixMilli(),
+		HasTs:  true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, fake.received)
+	require.Equal(t, 2, dropped)
+}
+
+func TestAppenderCreatedTimestamp(t *testing.T) {
+	fake := &counterSerializer{}
+	l := log2.NewNopLogger()
+	lbls := labels.FromStrings("__name__", "one")
+	now := time.Now().UnixMilli()
+
+	app := NewAppender(context.Background(), 1*time.Minute, fake, nil, false, func(types.AppenderStats) {}, l)
+	_, err := app.AppendCTZeroSample(0, lbls, now, now-10)
+	require.NoError(t, err)
+	require.Zero(t, fake.received)
+
+	app = NewAppender(context.Background(), 1*time.Minute, fake, nil, true, func(types.AppenderStats) {}, l)
+	_, err = app.AppendCTZeroSample(0, lbls, now, now-10)
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.received)
+	// A created timestamp that isn't older than the sample is ignored.
+	_, err = app.AppendCTZeroSample(0, lbls, now, now)
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.received)
+}
+
 var _ types.Serializer = (*fakeSerializer)(nil)
 
 type counterSerializer struct {
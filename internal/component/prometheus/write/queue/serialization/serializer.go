@@ -10,6 +10,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
 	"github.com/grafana/alloy/internal/runtime/logging/level"
+	"github.com/hashicorp/golang-lru/v2/simplelru"
 	"github.com/vladopajic/go-actor/actor"
 	"go.uber.org/atomic"
 )
@@ -33,6 +34,19 @@ type serializer struct {
 	msgpBuffer     []byte
 	stats          func(stats types.SerializerStats)
 	stopped        *atomic.Bool
+	// seen is a bounded set of recently seen series hashes, it is nil when churn tracking is disabled.
+	seen      *simplelru.LRU[uint64, struct{}]
+	newSeries int
+	// lastValues holds the last written sample of series that can be suppressed, it is nil when suppression is disabled.
+	lastValues       *simplelru.LRU[uint64, lastValue]
+	suppressCfg      types.SuppressUnchangedConfig
+	suppressNames    map[string]struct{}
+	unchangedDropped int
+}
+
+type lastValue struct {
+	value float64
+	ts    int64
 }
 
 func NewSerializer(cfg types.SerializerConfig, q types.FileStorage, stats func(stats types.SerializerStats), l log.Logger) (types.Serializer, error) {
@@ -51,6 +65,8 @@ func NewSerializer(cfg types.SerializerConfig, q types.FileStorage, stats func(s
 		stats:               stats,
 		stopped:             atomic.NewBool(false),
 	}
+	s.updateSeenCache(cfg.SeriesChurnCacheSize)
+	s.updateSuppressUnchanged(cfg.SuppressUnchanged)
 
 	return s, nil
 }
@@ -101,6 +117,8 @@ func (s *serializer) DoWork(ctx actor.Context) actor.WorkerStatus {
 		}
 		s.maxItemsBeforeFlush = int(cfg.MaxSignalsInBatch)
 		s.flushFrequency = cfg.FlushFrequency
+		s.updateSeenCache(cfg.SeriesChurnCacheSize)
+		s.updateSuppressUnchanged(cfg.SuppressUnchanged)
 		return actor.WorkerContinue
 	default:
 	}
@@ -112,6 +130,12 @@ func (s *serializer) DoWork(ctx actor.Context) actor.WorkerStatus {
 		if !ok {
 			return actor.WorkerEnd
 		}
+		s.trackChurn(item)
+		if s.isUnchanged(item) {
+			s.unchangedDropped++
+			types.PutTimeSeriesIntoPool(item)
+			return actor.WorkerContinue
+		}
 		s.series = append(s.series, item)
 		// If we would go over the max size then send, or if we have hit the flush duration then send.
 		if len(s.meta)+len(s.series) >= s.maxItemsBeforeFlush {
@@ -145,6 +169,64 @@ func (s *serializer) DoWork(ctx actor.Context) actor.WorkerStatus {
 	}
 }
 
+// updateSeenCache creates, resizes or removes the cache of recently seen series.
+func (s *serializer) updateSeenCache(size int) {
+	switch {
+	case size <= 0:
+		s.seen = nil
+	case s.seen == nil:
+		// NewLRU only errors on a non-positive size.
+		s.seen, _ = simplelru.NewLRU[uint64, struct{}](size, nil)
+	default:
+		s.seen.Resize(size)
+	}
+}
+
+// trackChurn counts series that have not been seen recently. The cache only holds the most recently seen series, so a
+// series evicted from it is counted again the next time it is seen.
+func (s *serializer) trackChurn(ts *types.TimeSeriesBinary) {
+	if s.seen == nil {
+		return
+	}
+	if !s.seen.Contains(ts.Hash) {
+		s.newSeries++
+	}
+	s.seen.Add(ts.Hash, struct{}{})
+}
+
+// updateSuppressUnchanged applies the suppression config, the cache of last values is reset on any change.
+func (s *serializer) updateSuppressUnchanged(cfg types.SuppressUnchangedConfig) {
+	s.suppressCfg = cfg
+	if len(cfg.MetricNames) == 0 || cfg.CacheSize <= 0 {
+		s.lastValues = nil
+		s.suppressNames = nil
+		return
+	}
+	s.suppressNames = make(map[string]struct{}, len(cfg.MetricNames))
+	for _, name := range cfg.MetricNames {
+		s.suppressNames[name] = struct{}{}
+	}
+	// NewLRU only errors on a non-positive size.
+	s.lastValues, _ = simplelru.NewLRU[uint64, lastValue](cfg.CacheSize, nil)
+}
+
+// isUnchanged returns true if the sample has the same value as the last written sample of the series and
+// MaxInterval has not passed since then. Otherwise the sample is recorded as the last written one.
+func (s *serializer) isUnchanged(ts *types.TimeSeriesBinary) bool {
+	if s.lastValues == nil || ts.Histograms.Histogram != nil || ts.Histograms.FloatHistogram != nil {
+		return false
+	}
+	if _, found := s.suppressNames[ts.Labels.Get("__name__")]; !found {
+		return false
+	}
+	last, found := s.lastValues.Get(ts.Hash)
+	if found && last.value == ts.Value && time.UnixMilli(ts.TS).Sub(time.UnixMilli(last.ts)) < s.suppressCfg.MaxInterval {
+		return true
+	}
+	s.lastValues.Add(ts.Hash, lastValue{value: ts.Value, ts: ts.TS})
+	return false
+}
+
 func (s *serializer) flushToDisk(ctx actor.Context) error {
 	var err error
 	defer func() {
@@ -214,9 +296,13 @@ func (s *serializer) storeStats(err error) {
 		}
 	}
 	s.stats(types.SerializerStats{
-		SeriesStored:    len(s.series),
-		MetadataStored:  len(s.meta),
-		Errors:          hasError,
-		NewestTimestamp: newestTS,
+		SeriesStored:     len(s.series),
+		MetadataStored:   len(s.meta),
+		Errors:           hasError,
+		NewestTimestamp:  newestTS,
+		NewSeries:        s.newSeries,
+		UnchangedDropped: s.unchangedDropped,
 	})
+	s.newSeries = 0
+	s.unchangedDropped = 0
 }
@@ -12,16 +12,16 @@ import (
 	"github.com/prometheus/prometheus/model/labels"
 )
 
-var lbls = labels.FromStrings("one", "two", "three", "four")
+var lbls = labels.FromStrings("__name__", "one", "two", "three")
 
 func BenchmarkAppender(b *testing.B) {
 	// This should be 0 allocs
 	b.ReportAllocs()
 	logger := log.NewNopLogger()
 	for i := 0; i < b.N; i++ {
-		app := NewAppender(context.Background(), 1*time.Hour, &fakeSerializer{}, logger)
+		app := NewAppender(context.Background(), 1*time.Hour, &fakeSerializer{}, nil, false, func(types.AppenderStats) {}, logger)
 		for j := 0; j < 10_000; j++ {
-			_, _ = app.Append(0, lbls, time.Now().Unix(), 1.1)
+			_, _ = app.Append(0, lbls, time.Now().UnixMilli(), 1.1)
 		}
 		_ = app.Commit()
 	}
@@ -48,7 +48,7 @@ func getTimeSeries(b *testing.B) []*types.TimeSeriesBinary {
 	series := make([]*types.TimeSeriesBinary, 0)
 	for j := 0; j < 10_000; j++ {
 		timeseries := types.GetTimeSeriesFromPool()
-		timeseries.TS = time.Now().Unix()
+		timeseries.TS = time.Now().UnixMilli()
 		timeseries.Value = rand.Float64()
 		timeseries.Labels = getLabels()
 		series = append(series, timeseries)
@@ -59,7 +59,7 @@ func getTimeSeries(b *testing.B) []*types.TimeSeriesBinary {
 func getSingleTimeSeries(b *testing.B) *types.TimeSeriesBinary {
 	b.Helper()
 	timeseries := types.GetTimeSeriesFromPool()
-	timeseries.TS = time.Now().Unix()
+	timeseries.TS = time.Now().UnixMilli()
 	timeseries.Value = rand.Float64()
 	timeseries.Labels = getLabels()
 	return timeseries
@@ -20,7 +20,7 @@ func TestRoundTripSerialization(t *testing.T) {
 	totalSeries := atomic.Int64{}
 	f := &fqq{t: t}
 	l := log.NewNopLogger()
-	start := time.Now().Add(-1 * time.Second).Unix()
+	start := time.Now().Add(-1 * time.Second).UnixMilli()
 
 	s, err := NewSerializer(types.SerializerConfig{
 		MaxSignalsInBatch: 10,
@@ -45,7 +45,7 @@ func TestRoundTripSerialization(t *testing.T) {
 				Value: fmt.Sprintf("value_%d_%d", i, j),
 			}
 			tss.Value = float64(i)
-			tss.TS = time.Now().Unix()
+			tss.TS = time.Now().UnixMilli()
 		}
 		sendErr := s.SendSeries(context.Background(), tss)
 		require.NoError(t, sendErr)
@@ -77,6 +77,80 @@ func TestUpdateConfig(t *testing.T) {
 	}, 5*time.Second, 100*time.Millisecond)
 }
 
+func TestSeriesChurn(t *testing.T) {
+	f := &fqq{t: t}
+	l := log.NewNopLogger()
+	newSeries := atomic.Int64{}
+	s, err := NewSerializer(types.SerializerConfig{
+		MaxSignalsInBatch:    10,
+		FlushFrequency:       5 * time.Second,
+		SeriesChurnCacheSize: 20,
+	}, f, func(stats types.SerializerStats) {
+		newSeries.Add(int64(stats.NewSeries))
+	}, l)
+	require.NoError(t, err)
+	s.Start()
+	defer s.Stop()
+	// Send the same 10 series twice, only the first time should count as new.
+	for i := 0; i < 20; i++ {
+		tss := types.GetTimeSeriesFromPool()
+		tss.Labels = make(labels.Labels, 10)
+		for j := 0; j < 10; j++ {
+			tss.Labels[j] = labels.Label{
+				Name:  fmt.Sprintf("name_%d_%d", i%10, j),
+				Value: fmt.Sprintf("value_%d_%d", i%10, j),
+			}
+		}
+		tss.Value = float64(i % 10)
+		tss.TS = time.Now().UnixMilli()
+		tss.Hash = tss.Labels.Hash()
+		sendErr := s.SendSeries(context.Background(), tss)
+		require.NoError(t, sendErr)
+	}
+	require.Eventually(t, func() bool {
+		return f.total.Load() == 20 && newSeries.Load() == 10
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestSuppressUnchanged(t *testing.T) {
+	s, err := NewSerializer(types.SerializerConfig{
+		MaxSignalsInBatch: 10,
+		FlushFrequency:    5 * time.Second,
+		SuppressUnchanged: types.SuppressUnchangedConfig{
+			MetricNames: []string{"gauge"},
+			MaxInterval: 1 * time.Minute,
+			CacheSize:   10,
+		},
+	}, &fqq{t: t}, func(stats types.SerializerStats) {}, log.NewNopLogger())
+	require.NoError(t, err)
+	ser := s.(*serializer)
+
+	// Timestamps are in milliseconds like the ones appended by Prometheus.
+	start := time.Now().UnixMilli()
+	makeTS := func(name string, offset time.Duration, v float64) *types.TimeSeriesBinary {
+		lbls := labels.FromStrings("__name__", name)
+		return &types.TimeSeriesBinary{
+			Labels: lbls,
+			Hash:   lbls.Hash(),
+			TS:     start + offset.Milliseconds(),
+			Value:  v,
+		}
+	}
+	// The first sample is always written.
+	require.False(t, ser.isUnchanged(makeTS("gauge", 0, 1)))
+	// Same value within the interval is suppressed.
+	require.True(t, ser.isUnchanged(makeTS("gauge", 10*time.Second, 1)))
+	require.True(t, ser.isUnchanged(makeTS("gauge", 59*time.Second, 1)))
+	// A changed value is written.
+	require.False(t, ser.isUnchanged(makeTS("gauge", 60*time.Second, 2)))
+	require.True(t, ser.isUnchanged(makeTS("gauge", 90*time.Second, 2)))
+	// Once the max interval passes the sample is written even if unchanged.
+	require.False(t, ser.isUnchanged(makeTS("gauge", 120*time.Second, 2)))
+	// Series that are not configured are never suppressed.
+	require.False(t, ser.isUnchanged(makeTS("counter", 0, 1)))
+	require.False(t, ser.isUnchanged(makeTS("counter", 10*time.Second, 1)))
+}
+
 var _ types.FileStorage = (*fqq)(nil)
 
 type fqq struct {
@@ -2,6 +2,8 @@ package queue
 
 import (
 	"fmt"
+	"net/http"
+	"slices"
 	"time"
 
 	"github.com/grafana/alloy/internal/component/prometheus/write/queue/types"
@@ -22,9 +24,40 @@ func defaultArgs() Arguments {
 
 type Arguments struct {
 	// TTL is how old a series can be.
-	TTL         time.Duration    `alloy:"ttl,attr,optional"`
-	Persistence Persistence      `alloy:"persistence,block,optional"`
-	Endpoints   []EndpointConfig `alloy:"endpoint,block"`
+	TTL time.Duration `alloy:"ttl,attr,optional"`
+	// How many recently seen series to track for series churn metrics, 0 disables tracking.
+	SeriesChurnCacheSize int                `alloy:"series_churn_cache_size,attr,optional"`
+	Persistence          Persistence        `alloy:"persistence,block,optional"`
+	Endpoints            []EndpointConfig   `alloy:"endpoint,block"`
+	SuppressUnchanged    *SuppressUnchanged `alloy:"suppress_unchanged,block,optional"`
+}
+
+// SuppressUnchanged drops samples of the listed gauges when their value has not changed.
+type SuppressUnchanged struct {
+	// Names of the gauges to suppress unchanged samples for. Counters should never be listed.
+	MetricNames []string `alloy:"metric_names,attr"`
+	// The longest a sample can be suppressed for.
+	MaxInterval time.Duration `alloy:"max_interval,attr,optional"`
+	// How many series to remember the last value of.
+	CacheSize int `alloy:"cache_size,attr,optional"`
+}
+
+func (s *SuppressUnchanged) SetToDefault() {
+	*s = SuppressUnchanged{
+		MaxInterval: 1 * time.Minute,
+		CacheSize:   10_000,
+	}
+}
+
+func (s *SuppressUnchanged) ToNativeType() types.SuppressUnchangedConfig {
+	if s == nil {
+		return types.SuppressUnchangedConfig{}
+	}
+	return types.SuppressUnchangedConfig{
+		MetricNames: s.MetricNames,
+		MaxInterval: s.MaxInterval,
+		CacheSize:   s.CacheSize,
+	}
 }
 
 type Persistence struct {
@@ -45,12 +78,21 @@ func (rc *Arguments) SetToDefault() {
 
 func defaultEndpointConfig() EndpointConfig {
 	return EndpointConfig{
-		Timeout:          30 * time.Second,
-		RetryBackoff:     1 * time.Second,
-		MaxRetryAttempts: 0,
-		BatchCount:       1_000,
-		FlushInterval:    1 * time.Second,
-		Parallelism:      4,
+		Timeout:             30 * time.Second,
+		RetryBackoff:        1 * time.Second,
+		MaxRetryAttempts:    0,
+		BatchCount:          1_000,
+		FlushInterval:       1 * time.Second,
+		Parallelism:         4,
+		Sharding:            types.ShardingHash,
+		Protocol:            types.ProtocolPrometheus,
+		RetryJitter:         true,
+		MetadataParallelism: 1,
+		MinFlushInterval:    1 * time.Second,
+		MaxFlushInterval:    30 * time.Second,
+		// The circuit breaker is disabled by default.
+		CircuitBreakerCooldown: 30 * time.Second,
+		RoundRobinCacheSize:    100_000,
 	}
 }
 
@@ -59,13 +101,121 @@ func (cc *EndpointConfig) SetToDefault() {
 }
 
 func (r *Arguments) Validate() error {
+	if r.SeriesChurnCacheSize < 0 {
+		return fmt.Errorf("series_churn_cache_size must be greater or equal to 0")
+	}
+	if r.SuppressUnchanged != nil {
+		if r.SuppressUnchanged.CacheSize <= 0 {
+			return fmt.Errorf("suppress_unchanged cache_size must be greater than 0")
+		}
+		if r.SuppressUnchanged.MaxInterval <= 0 {
+			return fmt.Errorf("suppress_unchanged max_interval must be greater than 0")
+		}
+	}
 	for _, conn := range r.Endpoints {
+		if conn.Parallelism == 0 {
+			return fmt.Errorf("parallelism must be greater than 0")
+		}
+		if conn.Timeout <= 0 {
+			return fmt.Errorf("timeout must be greater than 0")
+		}
 		if conn.BatchCount <= 0 {
 			return fmt.Errorf("batch_count must be greater than 0")
 		}
 		if conn.FlushInterval < 1*time.Second {
 			return fmt.Errorf("flush_interval must be greater or equal to 1s, the internal timers resolution is 1s")
 		}
+		if conn.HistogramBatchCount < 0 {
+			return fmt.Errorf("histogram_batch_count must be greater or equal to 0")
+		}
+		if conn.FlushStagger < 0 {
+			return fmt.Errorf("flush_stagger must be greater or equal to 0")
+		}
+		if conn.MaxRequestBytes < 0 {
+			return fmt.Errorf("max_request_bytes must be greater or equal to 0")
+		}
+		if conn.CompressionMinBytes < 0 {
+			return fmt.Errorf("compression_min_bytes must be greater or equal to 0")
+		}
+		if conn.StartupGracePeriod < 0 {
+			return fmt.Errorf("startup_grace_period must be greater or equal to 0")
+		}
+		if conn.LogThrottleInterval < 0 {
+			return fmt.Errorf("log_throttle_interval must be greater or equal to 0")
+		}
+		if conn.DrainTimeout < 0 {
+			return fmt.Errorf("drain_timeout must be greater or equal to 0")
+		}
+		if conn.Sharding == types.ShardingRoundRobin && conn.RoundRobinCacheSize <= 0 {
+			return fmt.Errorf("round_robin_cache_size must be greater than 0")
+		}
+		if conn.MaxPendingHistograms < 0 {
+			return fmt.Errorf("max_pending_histograms must be greater or equal to 0")
+		}
+		if conn.CircuitBreakerThreshold > 0 && conn.CircuitBreakerCooldown <= 0 {
+			return fmt.Errorf("circuit_breaker_cooldown must be greater than 0")
+		}
+		if conn.MaxConcurrentSends < 0 {
+			return fmt.Errorf("max_concurrent_sends must be greater or equal to 0")
+		}
+		if conn.MaxSamplesPerSecond < 0 {
+			return fmt.Errorf("max_samples_per_second must be greater or equal to 0")
+		}
+		for name := range conn.Headers {
+			if _, found := reservedHeaders[http.CanonicalHeaderKey(name)]; found {
+				return fmt.Errorf("headers can't set %q, it is set by prometheus.write.queue", name)
+			}
+		}
+		for _, t := range conn.AcceptedTypes {
+			switch t {
+			case types.DataTypeSample, types.DataTypeExemplar, types.DataTypeHistogram, types.DataTypeFloatHistogram:
+			default:
+				return fmt.Errorf("accepted_types must only contain %q, %q, %q or %q, got %q", types.DataTypeSample, types.DataTypeExemplar, types.DataTypeHistogram, types.DataTypeFloatHistogram, t)
+			}
+		}
+		if conn.AdaptiveFlushInterval {
+			if conn.MinFlushInterval < 1*time.Second {
+				return fmt.Errorf("min_flush_interval must be greater or equal to 1s, the internal timers resolution is 1s")
+			}
+			if conn.MinFlushInterval > conn.FlushInterval || conn.FlushInterval > conn.MaxFlushInterval {
+				return fmt.Errorf("flush_interval must be between min_flush_interval and max_flush_interval")
+			}
+		}
+		for _, code := range conn.RetryableStatusCodes {
+			if code < 300 || code > 599 {
+				return fmt.Errorf("retryable_status_codes must be between 300 and 599, got %d", code)
+			}
+			if slices.Contains(conn.NonRetryableStatusCodes, code) {
+				return fmt.Errorf("status code %d can't be in both retryable_status_codes and non_retryable_status_codes", code)
+			}
+		}
+		for _, code := range conn.NonRetryableStatusCodes {
+			if code < 300 || code > 599 {
+				return fmt.Errorf("non_retryable_status_codes must be between 300 and 599, got %d", code)
+			}
+		}
+		if conn.MetadataParallelism == 0 {
+			return fmt.Errorf("metadata_parallelism must be greater than 0")
+		}
+		switch conn.Compression {
+		// Empty picks the compression based on the protocol.
+		case "", types.CompressionSnappy, types.CompressionZstd, types.CompressionGzip, types.CompressionNone:
+		default:
+			return fmt.Errorf("compression must be one of %q, %q, %q or %q", types.CompressionSnappy, types.CompressionZstd, types.CompressionGzip, types.CompressionNone)
+		}
+		switch conn.Sharding {
+		case types.ShardingHash, types.ShardingRoundRobin:
+		default:
+			return fmt.Errorf("sharding must be one of %q or %q", types.ShardingHash, types.ShardingRoundRobin)
+		}
+		switch conn.Protocol {
+		case types.ProtocolPrometheus, types.ProtocolOTLP:
+		default:
+			return fmt.Errorf("protocol must be one of %q or %q", types.ProtocolPrometheus, types.ProtocolOTLP)
+		}
+		if conn.Protocol == types.ProtocolOTLP && conn.Compression == types.CompressionSnappy {
+			return fmt.Errorf("protocol %q doesn't support %q compression", types.ProtocolOTLP, types.CompressionSnappy)
+		}
 	}
 
 	return nil
@@ -89,22 +239,135 @@ type EndpointConfig struct {
 	// How many concurrent queues to have.
 	Parallelism    uint              `alloy:"parallelism,attr,optional"`
 	ExternalLabels map[string]string `alloy:"external_labels,attr,optional"`
+	// How many histograms to send per request, defaults to batch_count when 0.
+	HistogramBatchCount int `alloy:"histogram_batch_count,attr,optional"`
+	// Window to spread the first flush of each parallel queue across.
+	FlushStagger time.Duration `alloy:"flush_stagger,attr,optional"`
+	// Reduce the batch count when the endpoint responds with 413.
+	AdaptiveBatchCount bool `alloy:"adaptive_batch_count,attr,optional"`
+	// Codec used to compress requests, empty uses snappy or gzip for otlp.
+	Compression string `alloy:"compression,attr,optional"`
+	// Split batches whose compressed request is larger than this, 0 disables splitting.
+	MaxRequestBytes int `alloy:"max_request_bytes,attr,optional"`
+	// How series are spread across the parallel queues.
+	Sharding string `alloy:"sharding,attr,optional"`
+	// Randomize the retry backoff so parallel queues don't retry at the same time.
+	RetryJitter bool `alloy:"retry_jitter,attr,optional"`
+	// How many concurrent queues to send metadata with.
+	MetadataParallelism uint `alloy:"metadata_parallelism,attr,optional"`
+	// Adjust the flush interval based on how quickly batches fill.
+	AdaptiveFlushInterval bool `alloy:"adaptive_flush_interval,attr,optional"`
+	// Bounds of the flush interval when adaptive_flush_interval is enabled.
+	MinFlushInterval time.Duration `alloy:"min_flush_interval,attr,optional"`
+	MaxFlushInterval time.Duration `alloy:"max_flush_interval,attr,optional"`
+	// Build requests without sending them.
+	DryRun bool `alloy:"dry_run,attr,optional"`
+	// Label names to remove from every series before sending.
+	DropLabels []string `alloy:"drop_labels,attr,optional"`
+	// Status codes to always retry.
+	RetryableStatusCodes []int `alloy:"retryable_status_codes,attr,optional"`
+	// Status codes to never retry.
+	NonRetryableStatusCodes []int `alloy:"non_retryable_status_codes,attr,optional"`
+	// Interchangeable replicas of the url to spread requests across.
+	ReplicaURLs []string `alloy:"replica_urls,attr,optional"`
+	// Expose metrics for each connection.
+	PerConnectionMetrics bool `alloy:"per_connection_metrics,attr,optional"`
+	// Format to send requests in.
+	Protocol string `alloy:"protocol,attr,optional"`
+	// Most requests in flight at once, 0 is unlimited.
+	MaxConcurrentSends int `alloy:"max_concurrent_sends,attr,optional"`
+	// Data types to send, empty sends every type.
+	AcceptedTypes []string `alloy:"accepted_types,attr,optional"`
+	// Extra HTTP headers to add to every request.
+	Headers map[string]string `alloy:"headers,attr,optional"`
+	// Count series whose timestamps go back in time.
+	DetectOutOfOrder bool `alloy:"detect_out_of_order,attr,optional"`
+	// Consecutive recoverable failures before sending stops for the cooldown, 0 disables it.
+	CircuitBreakerThreshold uint `alloy:"circuit_breaker_threshold,attr,optional"`
+	// How long sending stops once the circuit breaker opens.
+	CircuitBreakerCooldown time.Duration `alloy:"circuit_breaker_cooldown,attr,optional"`
+	// Most samples sent per second, 0 is unlimited.
+	MaxSamplesPerSecond int `alloy:"max_samples_per_second,attr,optional"`
+	// Sort labels that aren't sorted by name.
+	ValidateLabelOrder bool `alloy:"validate_label_order,attr,optional"`
+	// Requests smaller than this are sent uncompressed, 0 always compresses.
+	CompressionMinBytes int `alloy:"compression_min_bytes,attr,optional"`
+	// How long partial batches wait to fill up after starting, 0 uses the flush interval.
+	StartupGracePeriod time.Duration `alloy:"startup_grace_period,attr,optional"`
+	// Send a zero sample at the created timestamp of a series.
+	SendCreatedTimestamps bool `alloy:"send_created_timestamps,attr,optional"`
+	// How often the same send error is logged, 0 logs every error.
+	LogThrottleInterval time.Duration `alloy:"log_throttle_interval,attr,optional"`
+	// How long to keep sending queued series when the component stops, 0 stops right away.
+	DrainTimeout time.Duration `alloy:"drain_timeout,attr,optional"`
+	// How many series round_robin sharding remembers the parallel batch of.
+	RoundRobinCacheSize int `alloy:"round_robin_cache_size,attr,optional"`
+	// How many histograms each parallel batch queues before queueing more blocks, 0 is unlimited.
+	MaxPendingHistograms int `alloy:"max_pending_histograms,attr,optional"`
+}
+
+// reservedHeaders are set by the component and can't be overridden by headers.
+var reservedHeaders = map[string]struct{}{
+	"Authorization":                     {},
+	"Content-Encoding":                  {},
+	"Content-Length":                    {},
+	"Content-Type":                      {},
+	"Retry-Attempt":                     {},
+	"User-Agent":                        {},
+	"X-Prometheus-Remote-Write-Version": {},
 }
 
 var UserAgent = fmt.Sprintf("Alloy/%s", version.Version)
 
 func (cc EndpointConfig) ToNativeType() types.ConnectionConfig {
 	tcc := types.ConnectionConfig{
-		URL:              cc.URL,
-		BearerToken:      cc.BearerToken,
-		UserAgent:        UserAgent,
-		Timeout:          cc.Timeout,
-		RetryBackoff:     cc.RetryBackoff,
-		MaxRetryAttempts: cc.MaxRetryAttempts,
-		BatchCount:       cc.BatchCount,
-		FlushInterval:    cc.FlushInterval,
-		ExternalLabels:   cc.ExternalLabels,
-		Connections:      cc.Parallelism,
+		URL:                     cc.URL,
+		BearerToken:             cc.BearerToken,
+		UserAgent:               UserAgent,
+		Timeout:                 cc.Timeout,
+		RetryBackoff:            cc.RetryBackoff,
+		MaxRetryAttempts:        cc.MaxRetryAttempts,
+		BatchCount:              cc.BatchCount,
+		FlushInterval:           cc.FlushInterval,
+		ExternalLabels:          cc.ExternalLabels,
+		Connections:             cc.Parallelism,
+		AdaptiveBatchCount:      cc.AdaptiveBatchCount,
+		FlushStagger:            cc.FlushStagger,
+		HistogramBatchCount:     cc.HistogramBatchCount,
+		Compression:             cc.Compression,
+		MaxRequestBytes:         cc.MaxRequestBytes,
+		Sharding:                cc.Sharding,
+		RetryJitter:             cc.RetryJitter,
+		MetadataParallelism:     cc.MetadataParallelism,
+		AdaptiveFlushInterval:   cc.AdaptiveFlushInterval,
+		MinFlushInterval:        cc.MinFlushInterval,
+		MaxFlushInterval:        cc.MaxFlushInterval,
+		DryRun:                  cc.DryRun,
+		DropLabels:              cc.DropLabels,
+		RetryableStatusCodes:    cc.RetryableStatusCodes,
+		NonRetryableStatusCodes: cc.NonRetryableStatusCodes,
+		ReplicaURLs:             cc.ReplicaURLs,
+		PerConnectionMetrics:    cc.PerConnectionMetrics,
+		Protocol:                cc.Protocol,
+		MaxConcurrentSends:      cc.MaxConcurrentSends,
+		Headers:                 cc.Headers,
+		DetectOutOfOrder:        cc.DetectOutOfOrder,
+		CircuitBreakerThreshold: cc.CircuitBreakerThreshold,
+		CircuitBreakerCooldown:  cc.CircuitBreakerCooldown,
+		MaxSamplesPerSecond:     cc.MaxSamplesPerSecond,
+		ValidateLabelOrder:      cc.ValidateLabelOrder,
+		CompressionMinBytes:     cc.CompressionMinBytes,
+		StartupGracePeriod:      cc.StartupGracePeriod,
+		LogThrottleInterval:     cc.LogThrottleInterval,
+		RoundRobinCacheSize:     cc.RoundRobinCacheSize,
+		MaxPendingHistograms:    cc.MaxPendingHistograms,
+	}
+	if tcc.Compression == "" {
+		// Prometheus remote write requires snappy, which OTLP doesn't support.
+		tcc.Compression = types.CompressionSnappy
+		if cc.Protocol == types.ProtocolOTLP {
+			tcc.Compression = types.CompressionGzip
+		}
 	}
 	if cc.BasicAuth != nil {
 		tcc.BasicAuth = &types.BasicAuth{
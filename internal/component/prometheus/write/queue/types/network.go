@@ -2,6 +2,7 @@ package types
 
 import (
 	"context"
+	"fmt"
 	"github.com/grafana/alloy/syntax/alloytypes"
 	"reflect"
 	"time"
@@ -15,6 +16,29 @@ type NetworkClient interface {
 	// UpdateConfig is a synchronous call and will only return once the config
 	// is applied or an error occurs.
 	UpdateConfig(ctx context.Context, cfg ConnectionConfig) error
+	// StopWithTimeout sends what is queued for up to the timeout and then stops, anything not sent by then is lost.
+	// Stop, like a timeout of 0, doesn't wait at all.
+	StopWithTimeout(timeout time.Duration)
+	// Status returns a snapshot of the queues, it is safe to call at any time.
+	Status() NetworkStatus
+}
+
+// NetworkStatus is a point in time snapshot of a NetworkClient.
+type NetworkStatus struct {
+	Connections         int
+	MetadataConnections int
+	// Unrouted is the series and metadata not yet routed to a connection.
+	Unrouted int64
+	// PendingSeries and PendingMetadata are queued in the connections and not yet batched.
+	PendingSeries   int64
+	PendingMetadata int64
+	// LastError is the most recent error that dropped a batch or failed it more than once, the next successful send
+	// clears it. LastErrorTime is when it happened.
+	LastError     error
+	LastErrorTime time.Time
+	// Protocol and Compression are what requests are currently sent with.
+	Protocol    string
+	Compression string
 }
 type ConnectionConfig struct {
 	URL              string
@@ -28,8 +52,89 @@ type ConnectionConfig struct {
 	FlushInterval    time.Duration
 	ExternalLabels   map[string]string
 	Connections      uint
+	// HistogramBatchCount is the most histograms sent in a request, MaxPendingHistograms bounds how many are queued.
+	// Defaults to BatchCount when 0.
+	HistogramBatchCount int
+	// FlushStagger spreads the first flush of each connection across this window.
+	FlushStagger time.Duration
+	// AdaptiveBatchCount reduces the batch count when the endpoint responds with 413.
+	AdaptiveBatchCount bool
+	// TTL is how old a series can be before it is dropped instead of retried.
+	TTL time.Duration
+	// Compression is the codec used for the request body, defaults to snappy when empty.
+	Compression string
+	// MaxRequestBytes splits batches whose compressed request is larger than this, 0 disables splitting.
+	MaxRequestBytes int
+	// Sharding is how series are spread across connections, defaults to hash when empty.
+	Sharding string
+	// RetryJitter randomizes RetryBackoff between 0 and RetryBackoff, a server provided Retry-After is used as is.
+	RetryJitter bool
+	// MetadataParallelism is how many concurrent metadata connections to have, defaults to 1 when 0.
+	MetadataParallelism uint
+	// AdaptiveFlushInterval adjusts the flush interval between MinFlushInterval and MaxFlushInterval
+	// based on how quickly batches fill.
+	AdaptiveFlushInterval bool
+	MinFlushInterval      time.Duration
+	MaxFlushInterval      time.Duration
+	// DryRun builds requests but never sends them, treating every send as successful.
+	DryRun bool
+	// DropLabels are label names removed from every series before it is sent, external labels are never removed.
+	DropLabels []string
+	// RetryableStatusCodes are always retried and NonRetryableStatusCodes are never retried, any other status code
+	// is retried if it is 5xx or 429.
+	RetryableStatusCodes    []int
+	NonRetryableStatusCodes []int
+	// ReplicaURLs are interchangeable replicas of URL, requests are round robined across all of them.
+	ReplicaURLs []string
+	// PerConnectionMetrics adds metrics labeled by the connection, this is useful to find an imbalance between them.
+	PerConnectionMetrics bool
+	// Protocol is the format requests are sent in, remote write or OTLP.
+	Protocol string
+	// MaxConcurrentSends limits the requests in flight across all loops, 0 is unlimited.
+	MaxConcurrentSends int
+	// Headers are added to every request.
+	Headers map[string]string
+	// DetectOutOfOrder counts and logs series whose timestamps go back in time within a batch.
+	DetectOutOfOrder bool
+	// CircuitBreakerThreshold is how many consecutive recoverable failures stop sending for CircuitBreakerCooldown,
+	// 0 disables the circuit breaker.
+	CircuitBreakerThreshold uint
+	CircuitBreakerCooldown  time.Duration
+	// MaxSamplesPerSecond limits the series sent per second across all loops, 0 is unlimited. Metadata isn't limited.
+	MaxSamplesPerSecond int
+	// ValidateLabelOrder sorts the labels of series that aren't sorted by name.
+	ValidateLabelOrder bool
+	// CompressionMinBytes sends requests smaller than this uncompressed, 0 always compresses.
+	CompressionMinBytes int
+	// StartupGracePeriod is the least time a partial batch waits before it is sent after the loop starts, so that
+	// batches fill up while the queue catches up. 0 uses FlushInterval.
+	StartupGracePeriod time.Duration
+	// LogThrottleInterval logs the same send error at most once per interval, repeats are logged at debug.
+	// 0 logs every error.
+	LogThrottleInterval time.Duration
+	// RoundRobinCacheSize is how many series the round robin sharding remembers the loop of, 0 uses a default.
+	RoundRobinCacheSize int
+	// MaxPendingHistograms is how many histograms each loop queues before queueing more blocks, 0 is unlimited.
+	MaxPendingHistograms int
 }
 
+const (
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+	CompressionGzip   = "gzip"
+	CompressionNone   = "none"
+)
+
+const (
+	ProtocolPrometheus = "prometheus"
+	ProtocolOTLP       = "otlp"
+)
+
+const (
+	ShardingHash       = "hash"
+	ShardingRoundRobin = "round_robin"
+)
+
 type BasicAuth struct {
 	Username string
 	Password string
@@ -38,3 +143,27 @@ type BasicAuth struct {
 func (cc ConnectionConfig) Equals(bb ConnectionConfig) bool {
 	return reflect.DeepEqual(cc, bb)
 }
+
+// Validate returns an error for a configuration the network client can't work with, such as no connections to
+// shard series across.
+func (cc ConnectionConfig) Validate() error {
+	if cc.Connections == 0 {
+		return fmt.Errorf("connections must be greater than 0")
+	}
+	if cc.BatchCount <= 0 {
+		return fmt.Errorf("batch count must be greater than 0")
+	}
+	if cc.HistogramBatchCount < 0 {
+		return fmt.Errorf("histogram batch count must be greater or equal to 0")
+	}
+	if cc.MaxPendingHistograms < 0 {
+		return fmt.Errorf("max pending histograms must be greater or equal to 0")
+	}
+	if cc.FlushInterval <= 0 {
+		return fmt.Errorf("flush interval must be greater than 0")
+	}
+	if cc.Timeout <= 0 {
+		return fmt.Errorf("timeout must be greater than 0")
+	}
+	return nil
+}
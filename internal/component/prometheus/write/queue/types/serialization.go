@@ -26,7 +26,7 @@ type SeriesGroup struct {
 
 // TimeSeriesBinary is an optimized format for handling metrics and metadata. It should never be instantiated directly
 // but instead use GetTimeSeriesFromPool and PutTimeSeriesSliceIntoPool. This allows us to reuse these objects and avoid
-// allocations.
+// allocations. TS is in milliseconds, like every Prometheus timestamp.
 type TimeSeriesBinary struct {
 	// Labels are not serialized to msgp, instead we store separately a dictionary of strings and use `LabelNames` and `LabelValues` to refer to the dictionary by ID.
 	Labels       labels.Labels `msg:"-"`
@@ -97,6 +97,11 @@ func (ts TimeSeriesBinary) IsMetadata() bool {
 	return ts.Labels.Has("__alloy_metadata_type__")
 }
 
+// IsHistogram is true if the series holds a histogram or float histogram instead of a sample.
+func (ts TimeSeriesBinary) IsHistogram() bool {
+	return ts.Histograms.Histogram != nil || ts.Histograms.FloatHistogram != nil
+}
+
 func (h *Histogram) ToPromHistogram() prompb.Histogram {
 	return prompb.Histogram{
 		Count:          &prompb.Histogram_CountInt{CountInt: h.Count.IntValue},
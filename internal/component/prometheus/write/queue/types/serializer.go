@@ -12,6 +12,47 @@ type SerializerConfig struct {
 	MaxSignalsInBatch uint32
 	// FlushFrequency controls how often to write to disk regardless of MaxSignalsInBatch.
 	FlushFrequency time.Duration
+	// SeriesChurnCacheSize is how many recently seen series are tracked to detect new series, 0 disables tracking.
+	SeriesChurnCacheSize int
+	// SuppressUnchanged drops samples whose value has not changed since the last one written.
+	SuppressUnchanged SuppressUnchangedConfig
+}
+
+// SuppressUnchangedConfig controls which series have unchanged samples dropped. This is only safe for gauges,
+// so only series with a metric name in MetricNames are considered.
+type SuppressUnchangedConfig struct {
+	MetricNames []string
+	// MaxInterval is the longest a sample can be suppressed for, this keeps the series from going stale.
+	MaxInterval time.Duration
+	// CacheSize is how many series to remember the last value for.
+	CacheSize int
+}
+
+const (
+	DataTypeSample         = "sample"
+	DataTypeExemplar       = "exemplar"
+	DataTypeHistogram      = "histogram"
+	DataTypeFloatHistogram = "float_histogram"
+)
+
+// AcceptedTypes is the set of data types an endpoint forwards, an empty set accepts every type.
+type AcceptedTypes map[string]struct{}
+
+func NewAcceptedTypes(dataTypes []string) AcceptedTypes {
+	accepted := make(AcceptedTypes, len(dataTypes))
+	for _, t := range dataTypes {
+		accepted[t] = struct{}{}
+	}
+	return accepted
+}
+
+// Accepts returns true if data of the type should be forwarded.
+func (a AcceptedTypes) Accepts(dataType string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	_, found := a[dataType]
+	return found
 }
 
 // Serializer handles converting a set of signals into a binary representation to be written to storage.
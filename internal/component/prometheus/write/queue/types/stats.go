@@ -1,6 +1,7 @@
 package types
 
 import (
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -9,10 +10,17 @@ import (
 // TODO @mattdurham separate this into more manageable chunks, and likely 3 stats series: series, metadata and new ones.
 
 type SerializerStats struct {
-	SeriesStored    int
-	MetadataStored  int
-	Errors          int
-	NewestTimestamp int64
+	SeriesStored     int
+	MetadataStored   int
+	Errors           int
+	NewestTimestamp  int64
+	NewSeries        int
+	UnchangedDropped int
+}
+
+type AppenderStats struct {
+	// InvalidDropped is how many series were dropped because they have no metric name.
+	InvalidDropped int
 }
 
 type PrometheusStats struct {
@@ -25,11 +33,42 @@ type PrometheusStats struct {
 	NetworkSentDuration              prometheus.Histogram
 	NetworkErrors                    prometheus.Counter
 	NetworkNewestOutTimeStampSeconds prometheus.Gauge
+	NetworkTTLDrops                  prometheus.Counter
+	NetworkUncompressedBytes         *prometheus.CounterVec
+	NetworkBatchCount                prometheus.Gauge
+	NetworkRequestSplits             prometheus.Counter
+	NetworkQueueUtilization          prometheus.Gauge
+	NetworkPendingSamples            prometheus.Gauge
+	NetworkPendingHistograms         prometheus.Gauge
+	NetworkRetryAfterSeconds         prometheus.Counter
+	NetworkLowestPendingTimestamp    prometheus.Gauge
+	NetworkLabelsDropped             prometheus.Counter
+	NetworkOutOfOrder                prometheus.Counter
+	NetworkCircuitBreakerState       prometheus.Gauge
+	NetworkBuildFailures             prometheus.Counter
+	NetworkInFlightSends             prometheus.Gauge
+	NetworkBatches                   *prometheus.CounterVec
+	NetworkRateLimitedSeconds        prometheus.Counter
+	NetworkLagSeconds                prometheus.Gauge
+	NetworkRequestsSent              prometheus.Counter
+	NetworkDryRunRequests            prometheus.Counter
+	NetworkUnsortedLabels            prometheus.Counter
+	NetworkSampleAge                 prometheus.Histogram
+	NetworkConnectDuration           prometheus.Histogram
+	NetworkTLSHandshakeDuration      prometheus.Histogram
+	// Per connection metrics only have series if PerConnectionMetrics is enabled.
+	NetworkConnectionSeriesSent   *prometheus.CounterVec
+	NetworkConnectionPending      *prometheus.GaugeVec
+	NetworkConnectionSentDuration *prometheus.HistogramVec
 
 	// Serializer Stats
 	SerializerInSeries                 prometheus.Counter
 	SerializerNewestInTimeStampSeconds prometheus.Gauge
 	SerializerErrors                   prometheus.Counter
+	SerializerNewSeries                prometheus.Counter
+	SerializerNewSeriesPerFlush        prometheus.Gauge
+	SerializerUnchangedDropped         prometheus.Counter
+	AppenderInvalidDropped             prometheus.Counter
 
 	// Backwards compatibility metrics
 	SamplesTotal    prometheus.Counter
@@ -53,6 +92,12 @@ type PrometheusStats struct {
 	RemoteStorageInTimestamp  prometheus.Gauge
 	RemoteStorageOutTimestamp prometheus.Gauge
 	RemoteStorageDuration     prometheus.Histogram
+
+	// timestampMut guards the newest timestamps the lag is computed from, the serializer and network update them
+	// from different goroutines.
+	timestampMut sync.Mutex
+	newestIn     int64
+	newestOut    int64
 }
 
 func NewStats(namespace, subsystem string, registry prometheus.Registerer) *PrometheusStats {
@@ -72,6 +117,30 @@ func NewStats(namespace, subsystem string, registry prometheus.Registerer) *Prom
 			Subsystem: subsystem,
 			Name:      "serializer_errors",
 		}),
+		SerializerNewSeries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "serializer_new_series",
+			Help:      "Number of series not found in the cache of the series_churn_cache_size most recently seen series, a series evicted from the cache is counted again when it is seen.",
+		}),
+		SerializerNewSeriesPerFlush: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "serializer_new_series_per_flush",
+			Help:      "Number of series not found in the cache of the series_churn_cache_size most recently seen series in the last flush.",
+		}),
+		SerializerUnchangedDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "serializer_unchanged_dropped",
+			Help:      "Number of samples dropped because their value did not change.",
+		}),
+		AppenderInvalidDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "appender_invalid_dropped",
+			Help:      "Number of series dropped because they have no metric name.",
+		}),
 		NetworkNewestOutTimeStampSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
@@ -116,6 +185,165 @@ func NewStats(namespace, subsystem string, registry prometheus.Registerer) *Prom
 			Subsystem: subsystem,
 			Name:      "network_errors",
 		}),
+		NetworkTTLDrops: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_ttl_dropped",
+		}),
+		NetworkUncompressedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_uncompressed_bytes",
+			Help:      "Estimated bytes sent before compression by type of data, the sum across types is exact.",
+		}, []string{"type"}),
+		NetworkBatchCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_batch_count",
+			Help:      "Sum of the batch counts of the parallel batches when adaptive_batch_count is enabled.",
+		}),
+		NetworkRequestSplits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_request_splits",
+			Help:      "Number of times a batch was split because the request was larger than max_request_bytes.",
+		}),
+		NetworkQueueUtilization: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_queue_utilization",
+			Help:      "Average number of series waiting in each queue as a multiple of batch_count, above 1 means more than a full batch is waiting.",
+		}),
+		NetworkPendingSamples: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_pending_samples",
+			Help:      "Number of samples and exemplars waiting in the queues of all connections.",
+		}),
+		NetworkPendingHistograms: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_pending_histograms",
+			Help:      "Number of histograms waiting in the queues of all connections, each connection holds at most max_pending_histograms.",
+		}),
+		NetworkRetryAfterSeconds: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_retry_after_seconds",
+			Help:      "Total seconds spent waiting to retry because of a Retry-After header.",
+		}),
+		NetworkSampleAge: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Subsystem:                   subsystem,
+			Name:                        "network_sample_age_seconds",
+			Help:                        "Age of each series when it is sent successfully.",
+			NativeHistogramBucketFactor: 1.1,
+		}),
+		NetworkConnectDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Subsystem:                   subsystem,
+			Name:                        "network_connect_duration_seconds",
+			Help:                        "Time to open a new connection to the endpoint.",
+			NativeHistogramBucketFactor: 1.1,
+		}),
+		NetworkTLSHandshakeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Subsystem:                   subsystem,
+			Name:                        "network_tls_handshake_duration_seconds",
+			Help:                        "Time to complete the TLS handshake of a new connection to the endpoint.",
+			NativeHistogramBucketFactor: 1.1,
+		}),
+		NetworkUnsortedLabels: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_unsorted_labels",
+			Help:      "Number of series whose labels were not sorted by name and were sorted before being sent.",
+		}),
+		NetworkRequestsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_requests_sent",
+			Help:      "Number of requests sent successfully.",
+		}),
+		NetworkDryRunRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_dry_run_requests",
+			Help:      "Number of requests built but not sent because dry_run is enabled.",
+		}),
+		NetworkLagSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_lag_seconds",
+			Help:      "Newest timestamp received minus the newest timestamp sent.",
+		}),
+		NetworkLowestPendingTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_lowest_pending_timestamp_seconds",
+			Help:      "Timestamp of the oldest series in the most recently attempted batch, 0 once a connection has nothing pending.",
+		}),
+		NetworkLabelsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_labels_dropped",
+			Help:      "Number of labels removed from series because they are listed in drop_labels.",
+		}),
+		NetworkOutOfOrder: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_out_of_order",
+			Help:      "Number of series with a timestamp older than an earlier sample of the same series in the batch.",
+		}),
+		NetworkBuildFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_build_request_failures",
+			Help:      "Number of requests that could not be built, the series in them are dropped without being sent.",
+		}),
+		NetworkCircuitBreakerState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_circuit_breaker_state",
+			Help:      "State of the circuit breaker, 0 is closed, 1 is open and 2 is half open.",
+		}),
+		NetworkInFlightSends: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_in_flight_sends",
+			Help:      "Number of requests currently being sent.",
+		}),
+		NetworkBatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_batches",
+			Help:      "Number of batches sent, by whether the batch was full, the flush interval passed or it was flushed.",
+		}, []string{"trigger"}),
+		NetworkRateLimitedSeconds: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_rate_limited_seconds",
+			Help:      "Total seconds batches waited because of the max samples per second limit.",
+		}),
+		NetworkConnectionSeriesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_connection_series_sent",
+			Help:      "Number of series sent successfully by each connection.",
+		}, []string{"connection"}),
+		NetworkConnectionPending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "network_connection_pending_series",
+			Help:      "Number of series queued and not yet batched by each connection.",
+		}, []string{"connection"}),
+		NetworkConnectionSentDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Subsystem:                   subsystem,
+			Name:                        "network_connection_duration_seconds",
+			Help:                        "Duration of sends by each connection.",
+			NativeHistogramBucketFactor: 1.1,
+		}, []string{"connection"}),
 		RemoteStorageOutTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "prometheus_remote_storage_queue_highest_sent_timestamp_seconds",
 		}),
@@ -177,9 +405,39 @@ func NewStats(namespace, subsystem string, registry prometheus.Registerer) *Prom
 		s.NetworkSeriesSent,
 		s.NetworkErrors,
 		s.NetworkNewestOutTimeStampSeconds,
+		s.NetworkTTLDrops,
+		s.NetworkUncompressedBytes,
+		s.NetworkBatchCount,
+		s.NetworkRequestSplits,
+		s.NetworkQueueUtilization,
+		s.NetworkPendingSamples,
+		s.NetworkPendingHistograms,
+		s.NetworkRetryAfterSeconds,
+		s.NetworkLowestPendingTimestamp,
+		s.NetworkLabelsDropped,
+		s.NetworkOutOfOrder,
+		s.NetworkCircuitBreakerState,
+		s.NetworkBuildFailures,
+		s.NetworkInFlightSends,
+		s.NetworkBatches,
+		s.NetworkRateLimitedSeconds,
+		s.NetworkLagSeconds,
+		s.NetworkRequestsSent,
+		s.NetworkDryRunRequests,
+		s.NetworkUnsortedLabels,
+		s.NetworkSampleAge,
+		s.NetworkConnectDuration,
+		s.NetworkTLSHandshakeDuration,
+		s.NetworkConnectionSeriesSent,
+		s.NetworkConnectionPending,
+		s.NetworkConnectionSentDuration,
 		s.SerializerInSeries,
 		s.SerializerErrors,
 		s.SerializerNewestInTimeStampSeconds,
+		s.SerializerNewSeries,
+		s.SerializerNewSeriesPerFlush,
+		s.SerializerUnchangedDropped,
+		s.AppenderInvalidDropped,
 	)
 	return s
 }
@@ -214,12 +472,69 @@ func (s *PrometheusStats) UpdateNetwork(stats NetworkStats) {
 	s.NetworkFailures.Add(float64(stats.TotalFailed()))
 	s.NetworkRetries429.Add(float64(stats.Total429()))
 	s.NetworkRetries5XX.Add(float64(stats.Total5XX()))
-	s.NetworkSentDuration.Observe(stats.SendDuration.Seconds())
-	s.RemoteStorageDuration.Observe(stats.SendDuration.Seconds())
+	s.NetworkTTLDrops.Add(float64(stats.TotalTTLDropped()))
+	// Only stats reported after a send have a duration.
+	if stats.SendDuration > 0 {
+		s.NetworkSentDuration.Observe(stats.SendDuration.Seconds())
+		s.RemoteStorageDuration.Observe(stats.SendDuration.Seconds())
+	}
+	if stats.ConnectDuration > 0 {
+		s.NetworkConnectDuration.Observe(stats.ConnectDuration.Seconds())
+	}
+	if stats.TLSHandshakeDuration > 0 {
+		s.NetworkTLSHandshakeDuration.Observe(stats.TLSHandshakeDuration.Seconds())
+	}
+	s.NetworkRequestSplits.Add(float64(stats.RequestSplits))
+	s.NetworkRequestsSent.Add(float64(stats.RequestsSent))
+	s.NetworkDryRunRequests.Add(float64(stats.DryRunRequests))
+	s.NetworkRetryAfterSeconds.Add(stats.RetryAfter.Seconds())
+	s.NetworkRateLimitedSeconds.Add(stats.RateLimited.Seconds())
+	s.NetworkLabelsDropped.Add(float64(stats.LabelsDropped))
+	s.NetworkOutOfOrder.Add(float64(stats.OutOfOrder))
+	s.NetworkUnsortedLabels.Add(float64(stats.UnsortedLabels))
+	s.NetworkBuildFailures.Add(float64(stats.BuildFailures))
+	s.NetworkInFlightSends.Add(float64(stats.InFlightSends))
+	if stats.BatchTrigger != "" {
+		s.NetworkBatches.WithLabelValues(stats.BatchTrigger).Inc()
+	}
+	if stats.Connection != "" {
+		s.NetworkConnectionSeriesSent.WithLabelValues(stats.Connection).Add(float64(stats.TotalSent()))
+		if stats.SendDuration > 0 {
+			s.NetworkConnectionSentDuration.WithLabelValues(stats.Connection).Observe(stats.SendDuration.Seconds())
+		}
+		if stats.PendingSeries != nil {
+			s.NetworkConnectionPending.WithLabelValues(stats.Connection).Set(float64(*stats.PendingSeries))
+		}
+	}
+	if stats.CircuitBreakerState != nil {
+		s.NetworkCircuitBreakerState.Set(float64(*stats.CircuitBreakerState))
+	}
+	if stats.QueueUtilization != nil {
+		s.NetworkQueueUtilization.Set(*stats.QueueUtilization)
+	}
+	if stats.PendingSamples != nil {
+		s.NetworkPendingSamples.Set(float64(*stats.PendingSamples))
+	}
+	if stats.PendingHistograms != nil {
+		s.NetworkPendingHistograms.Set(float64(*stats.PendingHistograms))
+	}
+	if stats.BatchCount != 0 {
+		s.NetworkBatchCount.Set(float64(stats.BatchCount))
+	}
+	if stats.LowestTimestamp != nil {
+		s.NetworkLowestPendingTimestamp.Set(toSeconds(*stats.LowestTimestamp))
+	}
+	if len(stats.Sent) > 0 {
+		now := time.Now().UnixMilli()
+		for _, ts := range stats.Sent {
+			s.NetworkSampleAge.Observe(toSeconds(max(now-ts.TS, 0)))
+		}
+	}
 	// The newest timestamp is no always sent.
 	if stats.NewestTimestamp != 0 {
-		s.RemoteStorageOutTimestamp.Set(float64(stats.NewestTimestamp))
-		s.NetworkNewestOutTimeStampSeconds.Set(float64(stats.NewestTimestamp))
+		s.RemoteStorageOutTimestamp.Set(toSeconds(stats.NewestTimestamp))
+		s.NetworkNewestOutTimeStampSeconds.Set(toSeconds(stats.NewestTimestamp))
+		s.updateLag(0, stats.NewestTimestamp)
 	}
 
 	s.SamplesTotal.Add(float64(stats.Series.SeriesSent))
@@ -234,17 +549,61 @@ func (s *PrometheusStats) UpdateNetwork(stats NetworkStats) {
 	s.RetriedHistogramsTotal.Add(float64(stats.Histogram.RetriedSamples))
 	s.RetriedMetadataTotal.Add(float64(stats.Metadata.RetriedSamples))
 
+	if stats.Series.UncompressedBytes > 0 {
+		s.NetworkUncompressedBytes.WithLabelValues("sample").Add(float64(stats.Series.UncompressedBytes))
+	}
+	if stats.Histogram.UncompressedBytes > 0 {
+		s.NetworkUncompressedBytes.WithLabelValues("histogram").Add(float64(stats.Histogram.UncompressedBytes))
+	}
+	if stats.Metadata.UncompressedBytes > 0 {
+		s.NetworkUncompressedBytes.WithLabelValues("metadata").Add(float64(stats.Metadata.UncompressedBytes))
+	}
+
 	s.MetadataBytesTotal.Add(float64(stats.MetadataBytes))
 	s.SentBytesTotal.Add(float64(stats.SeriesBytes))
 }
 
+// ResetConnectionMetrics removes the series of the per connection metrics, connections are recreated when the
+// configuration changes and there may be fewer of them.
+func (s *PrometheusStats) ResetConnectionMetrics() {
+	s.NetworkConnectionSeriesSent.Reset()
+	s.NetworkConnectionPending.Reset()
+	s.NetworkConnectionSentDuration.Reset()
+}
+
+// toSeconds converts a timestamp or duration in milliseconds to seconds.
+func toSeconds(ms int64) float64 {
+	return float64(ms) / 1000
+}
+
+// updateLag records a newer in or out timestamp, 0 leaves it unchanged. The lag is only set once both are known, so
+// it isn't the age of the first timestamp received before anything is sent.
+func (s *PrometheusStats) updateLag(in, out int64) {
+	s.timestampMut.Lock()
+	defer s.timestampMut.Unlock()
+	s.newestIn = max(s.newestIn, in)
+	s.newestOut = max(s.newestOut, out)
+	if s.newestIn == 0 || s.newestOut == 0 {
+		return
+	}
+	s.NetworkLagSeconds.Set(toSeconds(max(s.newestIn-s.newestOut, 0)))
+}
+
+func (s *PrometheusStats) UpdateAppender(stats AppenderStats) {
+	s.AppenderInvalidDropped.Add(float64(stats.InvalidDropped))
+}
+
 func (s *PrometheusStats) UpdateSerializer(stats SerializerStats) {
 	s.SerializerInSeries.Add(float64(stats.SeriesStored))
 	s.SerializerInSeries.Add(float64(stats.MetadataStored))
 	s.SerializerErrors.Add(float64(stats.Errors))
+	s.SerializerNewSeries.Add(float64(stats.NewSeries))
+	s.SerializerNewSeriesPerFlush.Set(float64(stats.NewSeries))
+	s.SerializerUnchangedDropped.Add(float64(stats.UnchangedDropped))
 	if stats.NewestTimestamp != 0 {
-		s.SerializerNewestInTimeStampSeconds.Set(float64(stats.NewestTimestamp))
-		s.RemoteStorageInTimestamp.Set(float64(stats.NewestTimestamp))
+		s.SerializerNewestInTimeStampSeconds.Set(toSeconds(stats.NewestTimestamp))
+		s.RemoteStorageInTimestamp.Set(toSeconds(stats.NewestTimestamp))
+		s.updateLag(stats.NewestTimestamp, 0)
 	}
 
 }
@@ -255,10 +614,59 @@ type NetworkStats struct {
 	Metadata        CategoryStats
 	SendDuration    time.Duration
 	NewestTimestamp int64
+	// LowestTimestamp is the oldest timestamp of the batch being sent, 0 once nothing is pending. It is only set when
+	// it is reported.
+	LowestTimestamp *int64
 	SeriesBytes     int
 	MetadataBytes   int
+	BatchCount      int
+	RequestSplits   int
+	// QueueUtilization is only set when it is reported.
+	QueueUtilization *float64
+	// PendingSamples and PendingHistograms are only set when the queue utilization is reported.
+	PendingSamples    *int64
+	PendingHistograms *int64
+	// RetryAfter is how long a retry waits because of a Retry-After header.
+	RetryAfter time.Duration
+	// LabelsDropped is how many labels were removed because of DropLabels.
+	LabelsDropped int
+	// Connection is the index of the connection that reported the stats, it is only set if PerConnectionMetrics is enabled.
+	Connection string
+	// PendingSeries is only set when the connection reports its queue.
+	PendingSeries *int64
+	// BuildFailures is how many requests could not be built.
+	BuildFailures int
+	// CircuitBreakerState is only set when the circuit breaker changes state.
+	CircuitBreakerState *int
+	// OutOfOrder is how many series went back in time within a batch, it is only counted if DetectOutOfOrder is set.
+	OutOfOrder int
+	// InFlightSends is the change in requests being sent, 1 when a request starts and -1 when it ends.
+	InFlightSends int
+	// BatchTrigger is only set when a batch is sent, it is one of the BatchTrigger constants.
+	BatchTrigger string
+	// RateLimited is how long a batch waited because of MaxSamplesPerSecond.
+	RateLimited time.Duration
+	// RequestsSent is how many requests were sent successfully.
+	RequestsSent int
+	// DryRunRequests is how many requests were built but not sent because DryRun is set.
+	DryRunRequests int
+	// UnsortedLabels is how many series had their labels sorted, it is only counted if ValidateLabelOrder is set.
+	UnsortedLabels int
+	// Sent are the series of a successful request, metadata isn't included. They are returned to the pool once the
+	// stats function returns so they must not be kept.
+	Sent []*TimeSeriesBinary
+	// ConnectDuration and TLSHandshakeDuration are only set when a request opens a new connection.
+	ConnectDuration      time.Duration
+	TLSHandshakeDuration time.Duration
 }
 
+// What caused a batch to be sent.
+const (
+	BatchTriggerFull  = "full"
+	BatchTriggerTimer = "timer"
+	BatchTriggerFlush = "flush"
+)
+
 func (ns NetworkStats) TotalSent() int {
 	return ns.Series.SeriesSent + ns.Histogram.SeriesSent + ns.Metadata.SeriesSent
 }
@@ -279,6 +687,10 @@ func (ns NetworkStats) Total5XX() int {
 	return ns.Series.RetriedSamples5XX + ns.Histogram.RetriedSamples5XX + ns.Metadata.RetriedSamples5XX
 }
 
+func (ns NetworkStats) TotalTTLDropped() int {
+	return ns.Series.TTLDroppedSamples + ns.Histogram.TTLDroppedSamples + ns.Metadata.TTLDroppedSamples
+}
+
 type CategoryStats struct {
 	RetriedSamples       int
 	RetriedSamples429    int
@@ -286,4 +698,6 @@ type CategoryStats struct {
 	SeriesSent           int
 	FailedSamples        int
 	NetworkSamplesFailed int
+	TTLDroppedSamples    int
+	UncompressedBytes    int
 }
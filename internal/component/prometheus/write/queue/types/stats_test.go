@@ -0,0 +1,46 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLag(t *testing.T) {
+	s := NewStats("alloy", "queue_series", prometheus.NewRegistry())
+
+	// Nothing has been sent yet.
+	s.UpdateSerializer(SerializerStats{NewestTimestamp: 100_000})
+	require.Zero(t, testutil.ToFloat64(s.NetworkLagSeconds))
+
+	// Timestamps are in milliseconds, the lag is in seconds.
+	s.UpdateNetwork(NetworkStats{NewestTimestamp: 90_000})
+	require.Equal(t, 10.0, testutil.ToFloat64(s.NetworkLagSeconds))
+
+	// An older timestamp doesn't move the lag back.
+	s.UpdateNetwork(NetworkStats{NewestTimestamp: 80_000})
+	require.Equal(t, 10.0, testutil.ToFloat64(s.NetworkLagSeconds))
+
+	s.UpdateSerializer(SerializerStats{NewestTimestamp: 120_500})
+	require.Equal(t, 30.5, testutil.ToFloat64(s.NetworkLagSeconds))
+	s.UpdateNetwork(NetworkStats{NewestTimestamp: 120_500})
+	require.Zero(t, testutil.ToFloat64(s.NetworkLagSeconds))
+}
+
+func TestSampleAge(t *testing.T) {
+	s := NewStats("alloy", "queue_series", prometheus.NewRegistry())
+	now := time.Now().UnixMilli()
+	s.UpdateNetwork(NetworkStats{
+		Sent: []*TimeSeriesBinary{{TS: now - 5_000}, {TS: now - 15_000}},
+	})
+
+	m := &dto.Metric{}
+	require.NoError(t, s.NetworkSampleAge.Write(m))
+	require.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+	// Allow for the second changing while updating.
+	require.InDelta(t, 20, m.GetHistogram().GetSampleSum(), 2)
+}